@@ -0,0 +1,419 @@
+// Command delayed inspects and manages go-delayed queues.
+//
+// Usage:
+//
+//	delayed [-addr :6379] [-password PASSWORD] [-db 0] COMMAND [ARGUMENTS]
+//
+// Commands:
+//
+//	stats QUEUE...                              print the lengths of the task, notification, processing and failed keys, and whether paused
+//	ls [-offset 0] [-limit 10] QUEUE            print the tasks in JSON without dequeuing them (alias: peek)
+//	enqueue QUEUE FUNC_PATH [ARG...]            enqueue a Go task, each ARG is a JSON value and structs are objects keyed by their field names
+//	enqueue -py QUEUE FUNC_PATH [ARGS [KWARGS]] enqueue a Python task, ARGS is a JSON array and KWARGS is a JSON object
+//	count QUEUE FUNC_PATH                       print the count of the tasks with the function path
+//	rm QUEUE TASK_ID...                         remove the tasks by their IDs
+//	rm -func FUNC_PATH QUEUE                    remove all the tasks with the function path
+//	cancel QUEUE TASK_ID...                     cancel the pending or running tasks by their IDs
+//	requeue-lost QUEUE...                       requeue the lost tasks of dead workers
+//	pause QUEUE...                              stop the workers from dequeuing tasks
+//	resume QUEUE...                             let the workers continue dequeuing tasks
+//	purge -y QUEUE...                           remove the pending and processing tasks of the queues, the paused state and failed tasks are kept
+//	workers QUEUE                               print the IDs of the live workers
+//	sweep [-interval 1m] QUEUE...               keep requeuing lost tasks until interrupted
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/yizhisec/go-delayed/delayed"
+)
+
+var errUsage = errors.New("invalid arguments")
+
+func main() {
+	err := run(os.Args[1:], os.Stdout)
+	if err != nil {
+		if err != errUsage && err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
+}
+
+type cli struct {
+	pool *redis.Pool
+	out  io.Writer
+}
+
+func run(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("delayed", flag.ContinueOnError)
+	addr := fs.String("addr", ":6379", "the address of the Redis server")
+	password := fs.String("password", "", "the password of the Redis server")
+	db := fs.Int("db", 0, "the database of the Redis server")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: delayed [options] stats|ls|peek|enqueue|count|rm|cancel|requeue-lost|pause|resume|purge|workers|sweep [arguments]")
+		fs.PrintDefaults()
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errUsage
+	}
+
+	c := &cli{
+		pool: delayed.NewRedisPool(*addr, redis.DialPassword(*password), redis.DialDatabase(*db)),
+		out:  out,
+	}
+	defer c.pool.Close()
+
+	cmd, args := fs.Arg(0), fs.Args()[1:]
+	switch cmd {
+	case "stats":
+		return c.stats(args)
+	case "ls", "peek":
+		return c.ls(args)
+	case "enqueue":
+		return c.enqueue(args)
+	case "count":
+		return c.count(args)
+	case "rm":
+		return c.rm(args)
+	case "cancel":
+		return c.cancel(args)
+	case "requeue-lost":
+		return c.requeueLost(args)
+	case "pause":
+		return c.pause(args, true)
+	case "resume":
+		return c.pause(args, false)
+	case "purge":
+		return c.purge(args)
+	case "workers":
+		return c.workers(args)
+	case "sweep":
+		return c.sweep(args)
+	default:
+		fs.Usage()
+		return errUsage
+	}
+}
+
+func (c *cli) queue(name string) *delayed.Queue {
+	return delayed.NewQueue(name, c.pool)
+}
+
+func (c *cli) stats(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "QUEUE\tTASKS\tNOTIFICATIONS\tPROCESSING\tWORKERS\tFAILED\tPAUSED")
+	for _, name := range args {
+		stats, err := c.queue(name).Stats()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%t\n", stats.Name, stats.Len, stats.NotiLen, stats.Processing, stats.Workers, stats.Failed, stats.Paused)
+	}
+	return w.Flush()
+}
+
+func (c *cli) ls(args []string) error {
+	fs := flag.NewFlagSet("ls", flag.ContinueOnError)
+	offset := fs.Int("offset", 0, "the index of the first task")
+	limit := fs.Int("limit", 10, "the max count of the tasks")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+
+	tasks, err := c.queue(fs.Arg(0)).Peek(*offset, *limit)
+	if err != nil {
+		return err
+	}
+	for i, task := range tasks {
+		data, err := json.Marshal(task)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.out, "%d\t%s\n", *offset+i, data)
+	}
+	return nil
+}
+
+func (c *cli) enqueue(args []string) error {
+	fs := flag.NewFlagSet("enqueue", flag.ContinueOnError)
+	py := fs.Bool("py", false, "enqueue a Python task")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return errUsage
+	}
+
+	args = fs.Args()
+	queueName, funcPath, args := args[0], args[1], args[2:]
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i], err = parseJSON(arg)
+		if err != nil {
+			return err
+		}
+	}
+
+	var task delayed.Task
+	if *py {
+		if len(values) > 2 {
+			return errUsage
+		}
+		var pyArgs, pyKwArgs interface{}
+		if len(values) > 0 {
+			pyArgs = values[0]
+		}
+		if len(values) > 1 {
+			pyKwArgs = values[1]
+		}
+		task = delayed.NewPyTask(funcPath, pyArgs, pyKwArgs)
+	} else {
+		task = delayed.NewGoTask(funcPath, values...)
+	}
+
+	err = c.queue(queueName).Enqueue(task)
+	if err != nil {
+		return err
+	}
+	if task.ID() != "" {
+		fmt.Fprintln(c.out, task.ID())
+	}
+	return nil
+}
+
+func (c *cli) count(args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	count, err := c.queue(args[0]).Count(args[1])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, count)
+	return nil
+}
+
+func (c *cli) rm(args []string) error {
+	fs := flag.NewFlagSet("rm", flag.ContinueOnError)
+	funcPath := fs.String("func", "", "remove all the tasks with the function path")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 || (*funcPath == "") == (fs.NArg() == 1) {
+		return errUsage
+	}
+
+	q := c.queue(fs.Arg(0))
+	ids := fs.Args()[1:]
+	if *funcPath != "" {
+		tasks, err := q.Find(func(task delayed.Task) bool {
+			return task.FuncPath() == *funcPath
+		})
+		if err != nil {
+			return err
+		}
+		for _, task := range tasks {
+			if task.ID() != "" {
+				ids = append(ids, task.ID())
+			}
+		}
+	}
+
+	count := 0
+	for _, id := range ids {
+		ok, err := q.Remove(id)
+		if err != nil {
+			return err
+		}
+		if ok {
+			count++
+		}
+	}
+	fmt.Fprintf(c.out, "removed %d tasks\n", count)
+	return nil
+}
+
+func (c *cli) cancel(args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+
+	q := c.queue(args[0])
+	for _, id := range args[1:] {
+		ok, err := q.Cancel(id)
+		if err != nil {
+			return err
+		}
+		if ok {
+			fmt.Fprintf(c.out, "%s: canceled\n", id)
+		} else {
+			fmt.Fprintf(c.out, "%s: not confirmed\n", id) // finished, not running yet, or its worker is dead
+		}
+	}
+	return nil
+}
+
+func (c *cli) requeueLost(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	for _, name := range args {
+		count, err := c.queue(name).RequeueLost()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.out, "%s: %d\n", name, count)
+	}
+	return nil
+}
+
+func (c *cli) pause(args []string, pause bool) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	for _, name := range args {
+		q := c.queue(name)
+		var err error
+		if pause {
+			err = q.Pause()
+		} else {
+			err = q.Resume()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *cli) purge(args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ContinueOnError)
+	yes := fs.Bool("y", false, "confirm to remove all the tasks")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errUsage
+	}
+	if !*yes {
+		return errors.New("refuse to purge without -y")
+	}
+
+	for _, name := range fs.Args() {
+		err = c.queue(name).Purge()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *cli) workers(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	ids, err := c.queue(args[0]).Workers()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		fmt.Fprintln(c.out, id)
+	}
+	return nil
+}
+
+func (c *cli) sweep(args []string) error {
+	fs := flag.NewFlagSet("sweep", flag.ContinueOnError)
+	interval := fs.Duration("interval", time.Minute, "the interval between sweeps")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errUsage
+	}
+
+	queues := make([]*delayed.Queue, fs.NArg())
+	for i, name := range fs.Args() {
+		queues[i] = c.queue(name)
+	}
+	sweeper := delayed.NewSweeper(queues...)
+	sweeper.SetInterval(*interval)
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+	go func() {
+		<-sigChan
+		sweeper.Stop()
+	}()
+
+	sweeper.Run()
+	return nil
+}
+
+// parseJSON parses a JSON value, and converts its numbers into int64 or float64.
+func parseJSON(s string) (v interface{}, err error) {
+	d := json.NewDecoder(strings.NewReader(s))
+	d.UseNumber()
+	err = d.Decode(&v)
+	if err != nil {
+		return
+	}
+	if d.More() {
+		return nil, fmt.Errorf("invalid JSON value: %s", s)
+	}
+	return convertNumbers(v), nil
+}
+
+func convertNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i, e := range v {
+			v[i] = convertNumbers(e)
+		}
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = convertNumbers(e)
+		}
+	}
+	return v
+}
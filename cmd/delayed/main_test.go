@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yizhisec/go-delayed/delayed"
+)
+
+const redisAddr = ":6379"
+
+func runCommand(t *testing.T, args ...string) string {
+	t.Helper()
+	var out bytes.Buffer
+	err := run(append([]string{"-addr", redisAddr}, args...), &out)
+	if err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+	return out.String()
+}
+
+func TestCommands(t *testing.T) {
+	q := delayed.NewQueue("test", delayed.NewRedisPool(redisAddr))
+	defer q.Clear()
+
+	id := strings.TrimSpace(runCommand(t, "enqueue", "test", "main.f", `[1, 2.5, ["a", {"b": 3}]]`))
+	if id == "" {
+		t.Fatal("no task ID")
+	}
+	runCommand(t, "enqueue", "-py", "test", "app.tasks:f", `[1]`, `{"a": "b"}`)
+
+	out := runCommand(t, "stats", "test")
+	if !strings.Contains(out, "test   2      2              0           0        0       false") {
+		t.Errorf("got stats:\n%s", out)
+	}
+
+	out = runCommand(t, "ls", "test")
+	want := `0	{"id":"` + id + `","func_path":"main.f","payload":[1,2.5,["a",{"b":3}]],"codec":"msgpack-map"}
+1	{"func_path":"app.tasks:f","args":[1],"kwargs":{"a":"b"}}
+`
+	if out != want {
+		t.Errorf("got tasks:\n%s\nwant:\n%s", out, want)
+	}
+
+	out = runCommand(t, "peek", "-offset", "1", "-limit", "1", "test")
+	if !strings.HasPrefix(out, "1\t") || strings.Count(out, "\n") != 1 {
+		t.Errorf("got tasks:\n%s", out)
+	}
+
+	out = runCommand(t, "count", "test", "main.f")
+	if out != "1\n" {
+		t.Errorf("got count %s", out)
+	}
+
+	runCommand(t, "enqueue", "test", "main.g")
+	runCommand(t, "enqueue", "test", "main.g")
+	out = runCommand(t, "rm", "-func", "main.g", "test")
+	if out != "removed 2 tasks\n" {
+		t.Errorf("got %s", out)
+	}
+	id2 := strings.TrimSpace(runCommand(t, "enqueue", "test", "main.g"))
+	out = runCommand(t, "cancel", "test", id2, "unknown")
+	if out != id2+": canceled\nunknown: not confirmed\n" {
+		t.Errorf("got %s", out)
+	}
+
+	out = runCommand(t, "rm", "test", id, "unknown")
+	if out != "removed 1 tasks\n" {
+		t.Errorf("got %s", out)
+	}
+	count, err := q.Len()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("got %d tasks after removed", count)
+	}
+
+	runCommand(t, "pause", "test")
+	out = runCommand(t, "stats", "test")
+	if !strings.Contains(out, "true") {
+		t.Errorf("got stats:\n%s", out)
+	}
+	runCommand(t, "resume", "test")
+	paused, err := q.IsPaused()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paused {
+		t.Error("the queue is still paused")
+	}
+
+	out = runCommand(t, "requeue-lost", "test")
+	if out != "test: 0\n" {
+		t.Errorf("got %s", out)
+	}
+
+	out = runCommand(t, "workers", "test")
+	if out != "" {
+		t.Errorf("got workers %s", out)
+	}
+
+	err = run([]string{"-addr", redisAddr, "purge", "test"}, &bytes.Buffer{})
+	if err == nil {
+		t.Error("purged without -y")
+	}
+	runCommand(t, "purge", "-y", "test")
+	count, err = q.Len()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("got %d tasks after purged", count)
+	}
+}
+
+type testArg struct {
+	A int
+	B string
+}
+
+func TestEnqueueStruct(t *testing.T) {
+	q := delayed.NewQueue("test", delayed.NewRedisPool(redisAddr), delayed.DequeueTimeout(time.Millisecond*2))
+	defer q.Clear()
+
+	var got int
+	w := delayed.NewWorker(q)
+	w.RegisterHandlerAs("main.f2", func(a int, b *testArg) {
+		got = a + b.A + len(b.B)
+	})
+
+	runCommand(t, "enqueue", "test", "main.f2", "1", `{"A": 1, "B": "test"}`)
+	task, err := q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task == nil {
+		t.Fatal("no task")
+	}
+	w.Execute(task)
+	q.Release()
+	if got != 6 {
+		t.Errorf("got %d", got)
+	}
+}
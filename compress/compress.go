@@ -0,0 +1,75 @@
+// Package compress provides go-delayed compressors by zstd and snappy, they are registered when this package is imported.
+//
+// Use them by delayed.QueueCompression() or the SetCompression() method of the tasks.
+package compress
+
+import (
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/yizhisec/go-delayed/delayed"
+)
+
+var (
+	// Zstd compresses tasks by zstd, it has a better compression ratio than snappy.
+	Zstd delayed.Compressor = zstdCompressor{}
+	// Snappy compresses tasks by snappy, it's faster than zstd.
+	Snappy delayed.Compressor = snappyCompressor{}
+
+	zstdEncoder, _ = zstd.NewWriter(nil) // EncodeAll() and DecodeAll() can be called concurrently
+	zstdDecoder    *zstd.Decoder
+	zstdDecoderErr error
+	zstdOnce       sync.Once
+)
+
+func init() {
+	delayed.RegisterCompressor(Zstd)
+	delayed.RegisterCompressor(Snappy)
+}
+
+type zstdCompressor struct{}
+
+func (zstdCompressor) Name() string {
+	return "zstd"
+}
+
+func (zstdCompressor) Compress(data []byte) ([]byte, error) {
+	return zstdEncoder.EncodeAll(data, nil), nil
+}
+
+func (zstdCompressor) Decompress(data []byte) ([]byte, error) {
+	zstdOnce.Do(func() { // created lazily, so it's limited by the MaxDecompressedSize set by the application
+		zstdDecoder, zstdDecoderErr = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(delayed.MaxDecompressedSize)))
+	})
+	if zstdDecoderErr != nil {
+		return nil, zstdDecoderErr
+	}
+
+	data, err := zstdDecoder.DecodeAll(data, nil)
+	if err == zstd.ErrDecoderSizeExceeded || err == zstd.ErrWindowSizeExceeded {
+		return nil, delayed.DecompressionLimitError
+	}
+	return data, err
+}
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) Name() string {
+	return "snappy"
+}
+
+func (snappyCompressor) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func (snappyCompressor) Decompress(data []byte) ([]byte, error) {
+	n, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, err
+	}
+	if n > delayed.MaxDecompressedSize {
+		return nil, delayed.DecompressionLimitError
+	}
+	return snappy.Decode(nil, data)
+}
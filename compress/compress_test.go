@@ -0,0 +1,61 @@
+package compress
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/yizhisec/go-delayed/delayed"
+)
+
+func TestCompressors(t *testing.T) {
+	large := strings.Repeat("test", 100)
+	for _, c := range []delayed.Compressor{Zstd, Snappy} {
+		t.Run(c.Name(), func(t *testing.T) {
+			task := delayed.NewGoTask("test", large)
+			task.SetCompression(c, 100)
+			data, err := task.Serialize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data) >= len(large) {
+				t.Errorf("compressed to %d bytes", len(data))
+			}
+
+			task, err = delayed.DeserializeGoTask(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var arg string
+			err = task.DecodeArg(&arg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if arg != large {
+				t.Errorf("got arg %q", arg)
+			}
+		})
+	}
+}
+
+func TestDecompressionLimit(t *testing.T) {
+	data, err := Zstd.Compress(make([]byte, delayed.MaxDecompressedSize+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Zstd.Decompress(data)
+	if err != delayed.DecompressionLimitError {
+		t.Errorf("got error %v", err)
+	}
+
+	defer func(size int) { delayed.MaxDecompressedSize = size }(delayed.MaxDecompressedSize)
+	delayed.MaxDecompressedSize = 100
+	data, err = Snappy.Compress(bytes.Repeat([]byte("test"), 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Snappy.Decompress(data)
+	if err != delayed.DecompressionLimitError {
+		t.Errorf("got error %v", err)
+	}
+}
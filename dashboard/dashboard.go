@@ -0,0 +1,365 @@
+// Package dashboard serves a web dashboard of go-delayed queues.
+//
+// It shows the queues, their workers, processing, pending and failed tasks,
+// and allows to remove pending tasks, to cancel processing tasks, to requeue or remove failed tasks,
+// and to pause, resume or purge queues.
+// The dashboard has no authentication, it should be protected by the application:
+//
+//	http.Handle("/delayed/", http.StripPrefix("/delayed", auth(dashboard.New(queue1, queue2))))
+//
+// The POST requests are protected from CSRF by a token stored in a cookie and submitted with the forms.
+package dashboard
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/yizhisec/go-delayed/delayed"
+)
+
+const (
+	defaultLimit = 20
+	maxLimit     = 1000
+
+	csrfCookie = "delayed_csrf"
+	csrfField  = "csrf_token"
+)
+
+// Dashboard is an http.Handler serving the dashboard of some queues.
+// All the links are relative, so it can be mounted under any path by http.StripPrefix().
+type Dashboard struct {
+	names  []string
+	queues map[string]*delayed.Queue
+	mux    *http.ServeMux
+}
+
+// New creates a dashboard of the queues.
+// The queues should be created with delayed.KeepFailed() to show their failed tasks.
+func New(queues ...*delayed.Queue) *Dashboard {
+	d := &Dashboard{
+		names:  make([]string, 0, len(queues)),
+		queues: make(map[string]*delayed.Queue, len(queues)),
+		mux:    http.NewServeMux(),
+	}
+	for _, q := range queues {
+		if _, ok := d.queues[q.Name()]; !ok {
+			d.names = append(d.names, q.Name())
+			d.queues[q.Name()] = q
+		}
+	}
+
+	d.mux.HandleFunc("/", d.index)
+	d.mux.HandleFunc("/queue", d.queue)
+	d.mux.HandleFunc("/remove", d.remove)
+	d.mux.HandleFunc("/cancel", d.cancel)
+	d.mux.HandleFunc("/requeue", d.requeue)
+	d.mux.HandleFunc("/delete", d.delete)
+	d.mux.HandleFunc("/pause", d.pause)
+	d.mux.HandleFunc("/resume", d.resume)
+	d.mux.HandleFunc("/purge", d.purge)
+	return d
+}
+
+// ServeHTTP serves the dashboard.
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mux.ServeHTTP(w, r)
+}
+
+func (d *Dashboard) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	stats := make([]*delayed.QueueStats, len(d.names))
+	for i, name := range d.names {
+		s, err := d.queues[name].Stats()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		stats[i] = s
+	}
+	render(w, indexTemplate, stats)
+}
+
+type taskView struct {
+	ID       string
+	FuncPath string
+	JSON     string // the decoded task in JSON
+}
+
+func newTaskView(task delayed.Task) taskView {
+	data, err := json.MarshalIndent(task, "", "  ")
+	if err != nil {
+		data = []byte(err.Error())
+	}
+	return taskView{
+		ID:       task.ID(),
+		FuncPath: task.FuncPath(),
+		JSON:     string(data),
+	}
+}
+
+type workerView struct {
+	ID    string
+	Alive bool
+	Task  *taskView // the processing task, nil if the worker is idle
+}
+
+type failedView struct {
+	taskView
+	Error       string
+	WorkerID    string
+	FailedAt    time.Time
+	DecodeError string // the kept record can't be deserialized
+}
+
+type queueView struct {
+	Stats            *delayed.QueueStats
+	Workers          []workerView // the live workers, and the dead ones with lost tasks
+	Pending          []taskView
+	Offset           int
+	Limit            int
+	PrevOffset       int
+	NextOffset       int // 0 if there are no more pending tasks
+	Failed           []failedView
+	FailedOffset     int // the failed tasks are paged by the same limit
+	PrevFailedOffset int
+	NextFailedOffset int    // 0 if there are no more failed tasks
+	CSRFToken        string // submitted with the forms
+}
+
+func (d *Dashboard) queue(w http.ResponseWriter, r *http.Request) {
+	q := d.getQueue(w, r)
+	if q == nil {
+		return
+	}
+
+	query := r.URL.Query()
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if limit <= 0 {
+		limit = defaultLimit
+	} else if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	failedOffset, _ := strconv.Atoi(query.Get("failed_offset"))
+	if failedOffset < 0 {
+		failedOffset = 0
+	}
+
+	view, err := d.queueView(q, offset, failedOffset, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	view.CSRFToken = csrfToken(w, r)
+	render(w, queueTemplate, view)
+}
+
+func (d *Dashboard) queueView(q *delayed.Queue, offset, failedOffset, limit int) (view *queueView, err error) {
+	view = &queueView{
+		Offset:       offset,
+		Limit:        limit,
+		FailedOffset: failedOffset,
+	}
+
+	view.Stats, err = q.Stats()
+	if err != nil {
+		return
+	}
+
+	ids, err := q.Workers()
+	if err != nil {
+		return
+	}
+	processing, err := q.Processing()
+	if err != nil {
+		return
+	}
+	for _, id := range ids {
+		worker := workerView{ID: id, Alive: true}
+		if task, ok := processing[id]; ok {
+			tv := newTaskView(task)
+			worker.Task = &tv
+			delete(processing, id)
+		}
+		view.Workers = append(view.Workers, worker)
+	}
+	for id, task := range processing { // the workers are dead, their tasks will be requeued by the sweeper
+		tv := newTaskView(task)
+		view.Workers = append(view.Workers, workerView{ID: id, Task: &tv})
+	}
+
+	pending, err := q.Peek(offset, limit)
+	if err != nil {
+		return
+	}
+	view.Pending = make([]taskView, len(pending))
+	for i, task := range pending {
+		view.Pending[i] = newTaskView(task)
+	}
+	if offset > limit {
+		view.PrevOffset = offset - limit
+	}
+	if offset+limit < view.Stats.Len {
+		view.NextOffset = offset + limit
+	}
+
+	failed, err := q.Failed(failedOffset, limit)
+	if err != nil {
+		return
+	}
+	view.Failed = make([]failedView, len(failed))
+	for i, task := range failed {
+		view.Failed[i] = failedView{
+			Error:    task.Error,
+			WorkerID: task.WorkerID,
+			FailedAt: task.FailedAt,
+		}
+		if task.Task != nil {
+			view.Failed[i].taskView = newTaskView(task.Task)
+		} else {
+			view.Failed[i].DecodeError = task.DecodeError.Error()
+		}
+	}
+	if failedOffset > limit {
+		view.PrevFailedOffset = failedOffset - limit
+	}
+	if failedOffset+limit < view.Stats.Failed {
+		view.NextFailedOffset = failedOffset + limit
+	}
+	return
+}
+
+func (d *Dashboard) remove(w http.ResponseWriter, r *http.Request) {
+	d.post(w, r, func(q *delayed.Queue) error {
+		_, err := q.Remove(r.PostFormValue("id"))
+		return err
+	})
+}
+
+func (d *Dashboard) cancel(w http.ResponseWriter, r *http.Request) {
+	d.post(w, r, func(q *delayed.Queue) error {
+		_, err := q.Cancel(r.PostFormValue("id"))
+		return err
+	})
+}
+
+func (d *Dashboard) requeue(w http.ResponseWriter, r *http.Request) {
+	d.post(w, r, func(q *delayed.Queue) error {
+		_, err := q.RequeueFailed(r.PostFormValue("id"))
+		return err
+	})
+}
+
+func (d *Dashboard) delete(w http.ResponseWriter, r *http.Request) {
+	d.post(w, r, func(q *delayed.Queue) error {
+		_, err := q.RemoveFailed(r.PostFormValue("id"))
+		return err
+	})
+}
+
+func (d *Dashboard) pause(w http.ResponseWriter, r *http.Request) {
+	d.post(w, r, func(q *delayed.Queue) error {
+		return q.Pause()
+	})
+}
+
+func (d *Dashboard) resume(w http.ResponseWriter, r *http.Request) {
+	d.post(w, r, func(q *delayed.Queue) error {
+		return q.Resume()
+	})
+}
+
+func (d *Dashboard) purge(w http.ResponseWriter, r *http.Request) {
+	d.post(w, r, func(q *delayed.Queue) error {
+		return q.Purge()
+	})
+}
+
+// post handles a POST request by calling fn, then redirects to the queue page.
+func (d *Dashboard) post(w http.ResponseWriter, r *http.Request, fn func(q *delayed.Queue) error) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkCSRFToken(r) {
+		http.Error(w, "invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	q := d.getQueue(w, r)
+	if q == nil {
+		return
+	}
+
+	err := fn(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// http.Redirect() can't be used, it resolves the relative URL by the path stripped by http.StripPrefix()
+	w.Header().Set("Location", "queue?name="+url.QueryEscape(q.Name()))
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+// csrfToken returns the CSRF token stored in the cookie, a new token is generated and stored if it's missing.
+// Other sites can't read the cookie, so they can't submit the forms with the token.
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(csrfCookie); err == nil && c.Value != "" {
+		return c.Value
+	}
+
+	token := delayed.RandHexString(16)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token
+}
+
+// checkCSRFToken checks if the submitted CSRF token matches the one stored in the cookie.
+func checkCSRFToken(r *http.Request) bool {
+	c, err := r.Cookie(csrfCookie)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.Value), []byte(r.PostFormValue(csrfField))) == 1
+}
+
+// getQueue returns the queue named by the "name" param, or writes a 404 response and returns nil.
+func (d *Dashboard) getQueue(w http.ResponseWriter, r *http.Request) *delayed.Queue {
+	q := d.queues[r.FormValue("name")]
+	if q == nil {
+		http.NotFound(w, r)
+	}
+	return q
+}
+
+func render(w http.ResponseWriter, t *template.Template, data interface{}) {
+	var buf bytes.Buffer
+	err := t.Execute(&buf, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
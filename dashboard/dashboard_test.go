@@ -0,0 +1,236 @@
+package dashboard
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yizhisec/go-delayed/delayed"
+)
+
+const (
+	redisAddr = ":6379"
+	testQueue = "test_dashboard" // differs from the queues of other packages, since packages are tested in parallel
+)
+
+func Fail(s string) error {
+	return errors.New(s)
+}
+
+func get(t *testing.T, h http.Handler, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+const testToken = "0123456789abcdef"
+
+// post submits the form with the CSRF token unless it has one.
+func post(t *testing.T, h http.Handler, target string, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	if _, ok := form[csrfField]; !ok {
+		form.Set(csrfField, testToken)
+	}
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: csrfCookie, Value: testToken})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestDashboard(t *testing.T) {
+	q := delayed.NewQueue(testQueue, delayed.NewRedisPool(redisAddr), delayed.DequeueTimeout(time.Millisecond*2), delayed.KeepFailed(10))
+	defer q.Clear()
+	w := delayed.NewWorker(q)
+	w.RegisterHandlers(Fail)
+	d := New(q)
+
+	failed := delayed.NewGoTaskOfFunc(Fail, "failed <task>")
+	q.Enqueue(failed)
+	task, err := q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Execute(task)
+	q.Release()
+
+	pending := delayed.NewGoTask("main.Pending", map[string]int{"count": 1})
+	q.Enqueue(pending)
+
+	rec := get(t, d, "/")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `href="queue?name=`+testQueue+`"`) {
+		t.Fatalf("got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = get(t, d, "/queue?name="+testQueue)
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", rec.Code, body)
+	}
+	for _, s := range []string{pending.ID(), "main.Pending", "&#34;count&#34;: 1", failed.ID(), "failed &lt;task&gt;"} {
+		if !strings.Contains(body, s) {
+			t.Errorf("%q is not in the page", s)
+		}
+	}
+
+	var token string
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == csrfCookie {
+			token = c.Value
+		}
+	}
+	if token == "" || !strings.Contains(body, `name="csrf_token" value="`+token+`"`) {
+		t.Errorf("the CSRF token %q is not in the page", token)
+	}
+	for _, form := range []url.Values{
+		{"name": {testQueue}, csrfField: {""}},
+		{"name": {testQueue}, csrfField: {token}}, // doesn't match the cookie
+	} {
+		if rec = post(t, d, "/purge", form); rec.Code != http.StatusForbidden {
+			t.Errorf("got %d", rec.Code)
+		}
+	}
+
+	if rec = get(t, d, "/queue?name=unknown"); rec.Code != http.StatusNotFound {
+		t.Errorf("got %d", rec.Code)
+	}
+	if rec = get(t, d, "/requeue?name="+testQueue+"&id="+failed.ID()); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("got %d", rec.Code)
+	}
+
+	rec = post(t, d, "/requeue", url.Values{"name": {testQueue}, "id": {failed.ID()}})
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "queue?name="+testQueue {
+		t.Fatalf("got %d: %v", rec.Code, rec.Header())
+	}
+	stats, err := q.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Len != 2 || stats.Failed != 0 {
+		t.Errorf("got %+v", stats)
+	}
+
+	rec = post(t, d, "/remove", url.Values{"name": {testQueue}, "id": {pending.ID()}})
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("got %d", rec.Code)
+	}
+	stats, err = q.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Len != 1 || stats.NotiLen != 1 {
+		t.Errorf("got %+v", stats)
+	}
+
+	rec = post(t, d, "/pause", url.Values{"name": {testQueue}})
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("got %d", rec.Code)
+	}
+	if rec = get(t, d, "/"); !strings.Contains(rec.Body.String(), "(paused)") {
+		t.Errorf("the queue is not paused: %s", rec.Body)
+	}
+	rec = post(t, d, "/resume", url.Values{"name": {testQueue}})
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("got %d", rec.Code)
+	}
+	if rec = get(t, d, "/"); strings.Contains(rec.Body.String(), "(paused)") {
+		t.Errorf("the queue is still paused: %s", rec.Body)
+	}
+
+	rec = post(t, d, "/purge", url.Values{"name": {testQueue}})
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("got %d", rec.Code)
+	}
+	count, err := q.Len()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("got %d tasks", count)
+	}
+}
+
+func TestDashboardDelete(t *testing.T) {
+	q := delayed.NewQueue(testQueue, delayed.NewRedisPool(redisAddr), delayed.KeepFailed(10))
+	defer q.Clear()
+	w := delayed.NewWorker(q)
+	w.RegisterHandlers(Fail)
+	d := New(q)
+
+	task := delayed.NewGoTaskOfFunc(Fail, "error")
+	task.Serialize()
+	w.Execute(task)
+
+	rec := post(t, d, "/delete", url.Values{"name": {testQueue}, "id": {task.ID()}})
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("got %d", rec.Code)
+	}
+	failed, err := q.Failed(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 0 {
+		t.Errorf("got %d failed tasks", len(failed))
+	}
+}
+
+func TestDashboardFailed(t *testing.T) {
+	q := delayed.NewQueue(testQueue, delayed.NewRedisPool(redisAddr), delayed.DequeueTimeout(time.Millisecond*2), delayed.KeepFailed(10))
+	defer q.Clear()
+	w := delayed.NewWorker(q)
+	w.RegisterHandlers(Fail)
+	d := New(q)
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		task := delayed.NewGoTaskOfFunc(Fail, "failed")
+		task.Serialize()
+		w.Execute(task)
+		ids = append(ids, task.ID())
+	}
+
+	conn := delayed.NewRedisPool(redisAddr).Get()
+	defer conn.Close()
+	noID := []byte{0x92, 0xa6, 'm', 'a', 'i', 'n', '.', 'f', 0xc0} // ["main.f", nil]
+	record := append([]byte{0x94, 0xc4, byte(len(noID))}, noID...)
+	record = append(record, 0xa5, 'e', 'r', 'r', 'o', 'r', 0xa0, 0x00) // [noID, "error", "", 0]
+	for _, r := range [][]byte{record, {1, 2, 3}} {
+		if _, err := conn.Do("LPUSH", testQueue+"_failed", r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := get(t, d, "/queue?name="+testQueue+"&limit=2")
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", rec.Code, body)
+	}
+	for _, s := range []string{"Undecodable record", "main.f", "failed_offset=2"} {
+		if !strings.Contains(body, s) {
+			t.Errorf("%q is not in the page", s)
+		}
+	}
+	if strings.Contains(body, `name="id" value=""`) || strings.Contains(body, ids[0]) {
+		t.Errorf("got page %s", body)
+	}
+
+	rec = get(t, d, "/queue?name="+testQueue+"&limit=2&failed_offset=2")
+	body = rec.Body.String()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", rec.Code, body)
+	}
+	for _, id := range ids {
+		if !strings.Contains(body, `name="id" value="`+id+`"`) {
+			t.Errorf("%q is not in the page", id)
+		}
+	}
+	if strings.Contains(body, "Undecodable record") {
+		t.Errorf("got page %s", body)
+	}
+}
@@ -0,0 +1,115 @@
+package dashboard
+
+import "html/template"
+
+const layoutHTML = `{{define "head"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.}} - go-delayed</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+pre { margin: 0; max-height: 20em; overflow: auto; }
+form { display: inline; }
+.dead { color: #c00; }
+.paused { color: #c60; }
+.error { color: #c00; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+{{end}}
+{{define "foot"}}</body>
+</html>
+{{end}}
+{{define "task"}}<details><summary>{{.FuncPath}}</summary><pre>{{.JSON}}</pre></details>{{end}}
+{{define "csrf"}}<input type="hidden" name="csrf_token" value="{{.}}">{{end}}`
+
+const indexHTML = `{{template "head" "Queues"}}
+<h1>Queues</h1>
+<table>
+<tr><th>Queue</th><th>Pending</th><th>Notifications</th><th>Processing</th><th>Workers</th><th>Failed</th></tr>
+{{range .}}<tr>
+<td><a href="queue?name={{.Name}}">{{.Name}}</a>{{if .Paused}} <span class="paused">(paused)</span>{{end}}</td>
+<td>{{.Len}}</td>
+<td>{{.NotiLen}}</td>
+<td>{{.Processing}}</td>
+<td>{{.Workers}}</td>
+<td>{{.Failed}}</td>
+</tr>{{end}}
+</table>
+{{template "foot"}}`
+
+const queueHTML = `{{template "head" .Stats.Name}}
+{{$name := .Stats.Name}}
+{{$token := .CSRFToken}}
+<p><a href="./">Queues</a></p>
+<h1>{{$name}}</h1>
+<p>
+{{.Stats.Len}} pending, {{.Stats.NotiLen}} notifications, {{.Stats.Processing}} processing, {{.Stats.Workers}} workers, {{.Stats.Failed}} failed
+{{if .Stats.Paused}}<span class="paused">(paused)</span>
+<form method="post" action="resume">{{template "csrf" $token}}<input type="hidden" name="name" value="{{$name}}"><button>Resume</button></form>
+{{else}}<form method="post" action="pause">{{template "csrf" $token}}<input type="hidden" name="name" value="{{$name}}"><button>Pause</button></form>
+{{end}}<form method="post" action="purge" onsubmit="return confirm('Remove all the tasks of {{$name}}?')">
+{{template "csrf" $token}}<input type="hidden" name="name" value="{{$name}}"><button>Purge</button>
+</form>
+</p>
+
+<h2>Workers</h2>
+<table>
+<tr><th>Worker</th><th>Processing task</th><th></th></tr>
+{{range .Workers}}<tr>
+<td>{{.ID}}{{if not .Alive}} <span class="dead">(dead)</span>{{end}}</td>
+<td>{{with .Task}}{{.ID}} {{template "task" .}}{{else}}idle{{end}}</td>
+<td>{{with .Task}}{{if .ID}}<form method="post" action="cancel">{{template "csrf" $token}}<input type="hidden" name="name" value="{{$name}}"><input type="hidden" name="id" value="{{.ID}}"><button>Cancel</button></form>{{end}}{{end}}</td>
+</tr>{{else}}<tr><td colspan="3">No workers.</td></tr>{{end}}
+</table>
+
+<h2>Pending tasks</h2>
+<table>
+<tr><th>#</th><th>ID</th><th>Task</th><th></th></tr>
+{{$offset := .Offset}}
+{{range $i, $task := .Pending}}<tr>
+<td>{{add $offset $i}}</td>
+<td>{{.ID}}</td>
+<td>{{template "task" .}}</td>
+<td>{{if .ID}}<form method="post" action="remove">{{template "csrf" $token}}<input type="hidden" name="name" value="{{$name}}"><input type="hidden" name="id" value="{{.ID}}"><button>Remove</button></form>{{end}}</td>
+</tr>{{else}}<tr><td colspan="4">No pending tasks.</td></tr>{{end}}
+</table>
+<p>
+{{if .Offset}}<a href="queue?name={{$name}}&offset={{.PrevOffset}}&failed_offset={{.FailedOffset}}&limit={{.Limit}}">Previous</a>{{end}}
+{{if .NextOffset}}<a href="queue?name={{$name}}&offset={{.NextOffset}}&failed_offset={{.FailedOffset}}&limit={{.Limit}}">Next</a>{{end}}
+</p>
+
+<h2>Failed tasks</h2>
+<table>
+<tr><th>Failed at</th><th>ID</th><th>Task</th><th>Error</th><th>Worker</th><th></th></tr>
+{{range .Failed}}<tr>
+{{if .DecodeError}}<td colspan="6" class="error">Undecodable record: {{.DecodeError}}</td>
+{{else}}<td>{{.FailedAt.Format "2006-01-02 15:04:05"}}</td>
+<td>{{.ID}}</td>
+<td>{{template "task" .}}</td>
+<td class="error">{{.Error}}</td>
+<td>{{.WorkerID}}</td>
+<td>{{if .ID}}
+<form method="post" action="requeue">{{template "csrf" $token}}<input type="hidden" name="name" value="{{$name}}"><input type="hidden" name="id" value="{{.ID}}"><button>Requeue</button></form>
+<form method="post" action="delete">{{template "csrf" $token}}<input type="hidden" name="name" value="{{$name}}"><input type="hidden" name="id" value="{{.ID}}"><button>Delete</button></form>
+{{end}}</td>
+{{end}}</tr>{{else}}<tr><td colspan="6">No failed tasks.</td></tr>{{end}}
+</table>
+<p>
+{{if .FailedOffset}}<a href="queue?name={{$name}}&offset={{.Offset}}&failed_offset={{.PrevFailedOffset}}&limit={{.Limit}}">Previous</a>{{end}}
+{{if .NextFailedOffset}}<a href="queue?name={{$name}}&offset={{.Offset}}&failed_offset={{.NextFailedOffset}}&limit={{.Limit}}">Next</a>{{end}}
+</p>
+{{template "foot"}}`
+
+var funcs = template.FuncMap{
+	"add": func(a, b int) int { return a + b },
+}
+
+var (
+	indexTemplate = template.Must(template.New("index").Funcs(funcs).Parse(layoutHTML + indexHTML))
+	queueTemplate = template.Must(template.New("queue").Funcs(funcs).Parse(layoutHTML + queueHTML))
+)
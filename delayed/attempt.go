@@ -0,0 +1,59 @@
+package delayed
+
+import "github.com/gomodule/redigo/redis"
+
+const (
+	attemptKeySuffix = "_attempt:"
+
+	// attemptExpiration is how long the attempt count of an unfinished or failed task is kept, in seconds.
+	attemptExpiration = 7 * 24 * 3600
+
+	// KEYS: attempt_key
+	// ARGV: expiration
+	// returns: the attempt count
+	countAttemptScript = `local count = redis.call('incr', KEYS[1])
+redis.call('expire', KEYS[1], ARGV[1])
+return count`
+)
+
+var countAttempt = redis.NewScript(1, countAttemptScript)
+
+// Attempt returns how many times the task has been executed, including the current execution.
+// It's counted in Redis by the task ID, so an execution after the task is requeued by RequeueLost() or RequeueFailed() is counted too.
+// It returns 0 if the task is not being executed by a worker, or it has no ID.
+// The count is kept until the task succeeded or it's not kept by KeepFailed() after it failed.
+func (t *GoTask) Attempt() int {
+	return t.attempt
+}
+
+// countAttempt increases the attempt count of a task about to be executed.
+// The count is not necessary to execute the task, so the error is logged and the task is executed with attempt 0.
+func (q *Queue) countAttempt(t *GoTask) {
+	t.attempt = 0
+	if q == nil || t.raw.ID == "" { // q may be nil if a Worker was not created by NewWorker()
+		return
+	}
+
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	count, err := redis.Int(countAttempt.Do(conn, q.name+attemptKeySuffix+t.raw.ID, attemptExpiration))
+	if err != nil {
+		q.logger.Error("Failed to count attempt.", "queue", q.name, "worker_id", q.workerID, "task_id", t.raw.ID, "error", err)
+		return
+	}
+	t.attempt = count
+}
+
+// clearAttempt deletes the attempt count of a finished task, which won't be executed again.
+func (q *Queue) clearAttempt(t *GoTask) error {
+	if t.attempt == 0 {
+		return nil
+	}
+
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("DEL", q.name+attemptKeySuffix+t.raw.ID)
+	return err
+}
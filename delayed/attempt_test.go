@@ -0,0 +1,67 @@
+package delayed
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestAttempt(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2), KeepFailed(10))
+	defer q.Clear()
+	var attempts []int
+	w := NewWorker(q, InterceptExecute(func(ctx context.Context, w *Worker, task *GoTask, next func(context.Context) error) error {
+		attempts = append(attempts, task.Attempt())
+		return next(ctx)
+	}))
+	w.RegisterHandlers(errorFunc)
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	failed := NewGoTaskOfFunc(errorFunc, "error")
+	err := q.Enqueue(failed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := q.name + attemptKeySuffix + failed.ID()
+	defer conn.Do("DEL", key)
+	for i := 0; i < 2; i++ {
+		task, err := q.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.Attempt() != 0 {
+			t.Errorf("got attempt %d before executing", task.Attempt())
+		}
+		w.Execute(task)
+		q.Release()
+		ok, err := q.RequeueFailed(failed.ID())
+		if err != nil || !ok {
+			t.Fatalf("failed to requeue the task: %v", err)
+		}
+	}
+	count, err := redis.Int(conn.Do("GET", key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("got count %d", count)
+	}
+
+	succeeded := NewGoTaskOfFunc(errorFunc, "")
+	succeeded.Serialize()
+	w.Execute(succeeded)
+	exists, err := redis.Bool(conn.Do("EXISTS", q.name+attemptKeySuffix+succeeded.ID()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("the attempt count of the succeeded task is kept")
+	}
+
+	if len(attempts) != 3 || attempts[0] != 1 || attempts[1] != 2 || attempts[2] != 1 {
+		t.Errorf("got attempts %v", attempts)
+	}
+}
@@ -0,0 +1,200 @@
+package delayed
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	blobKeySeparator      = "/"
+	blobTempFileExtension = ".tmp"
+)
+
+var (
+	NoBlobStoreError    = errors.New("No blob store to load the payload")
+	InvalidBlobKeyError = errors.New("Invalid blob key")
+	BlobNotFoundError   = errors.New("Blob not found")
+)
+
+// BlobStore stores the large payloads of GoTasks outside Redis, see QueueBlobStore().
+// It should be shared by the producers and the workers.
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error) // returns BlobNotFoundError if the key doesn't exist
+	Delete(ctx context.Context, key string) error        // returns nil if the key doesn't exist
+}
+
+// QueueBlobStore offloads the payloads larger than threshold bytes of the GoTasks enqueued to the queue into the blob store,
+// only the keys of the blobs are enqueued.
+// The worker fetches the payload before executing the task, and deletes it after the task succeeded.
+// The blobs of the tasks failed, removed or canceled are kept, so they should be expired by the blob store.
+func QueueBlobStore(store BlobStore, threshold int) QueueOption {
+	return func(q *Queue) {
+		q.blobStore = store
+		q.blobThreshold = threshold
+	}
+}
+
+// offload stores the payload (maybe encrypted) of a GoTask in the blob store if it's larger than the threshold.
+func (q *Queue) offload(ctx context.Context, t *GoTask) error {
+	if q.blobStore == nil || t.raw.Blob != "" {
+		return nil
+	}
+
+	_, err := t.Serialize()
+	if err != nil {
+		return err
+	}
+	if len(t.raw.Payload) <= q.blobThreshold {
+		return nil
+	}
+
+	id := t.raw.ID
+	if id == "" {
+		id = newTaskID()
+	}
+	key := q.name + blobKeySeparator + id
+	err = q.blobStore.Put(ctx, key, t.raw.Payload)
+	if err != nil {
+		return err
+	}
+	t.setBlob(key)
+	return nil
+}
+
+// loadBlob fetches the payload of a GoTask from the blob store.
+func (q *Queue) loadBlob(ctx context.Context, t *GoTask) error {
+	if t.raw.Blob == "" || len(t.raw.Payload) > 0 {
+		return nil
+	}
+	if q.blobStore == nil {
+		return NoBlobStoreError
+	}
+
+	payload, err := q.blobStore.Get(ctx, t.raw.Blob)
+	if err != nil {
+		return err
+	}
+	t.raw.Payload = payload // the serialized data is not changed, so the task is still stored with the key
+	return nil
+}
+
+// deleteBlob deletes the payload of a finished GoTask from the blob store.
+func (q *Queue) deleteBlob(t *GoTask) error {
+	if t.raw.Blob == "" || q.blobStore == nil {
+		return nil
+	}
+	return q.blobStore.Delete(context.Background(), t.raw.Blob)
+}
+
+// FileBlobStore stores the blobs as files in a directory, eg: a directory on a network file system.
+type FileBlobStore struct {
+	dir string
+}
+
+// NewFileBlobStore creates a FileBlobStore of the directory, which is created when the first blob is put.
+func NewFileBlobStore(dir string) *FileBlobStore {
+	return &FileBlobStore{dir: dir}
+}
+
+// path returns the path of the file of a key, the key shouldn't refer to a file outside the directory.
+func (s *FileBlobStore) path(key string) (string, error) {
+	if key == "" || strings.Contains(key, "..") || strings.HasPrefix(key, blobKeySeparator) {
+		return "", InvalidBlobKeyError
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes the data to a temporary file, and renames it to the file of the key, so a partially written blob is never read.
+func (s *FileBlobStore) Put(_ context.Context, key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + "." + RandHexString(8) + blobTempFileExtension
+	err = ioutil.WriteFile(tmpPath, data, 0644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
+
+// Get reads the file of the key.
+func (s *FileBlobStore) Get(_ context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, BlobNotFoundError
+	}
+	return data, err
+}
+
+// Delete removes the file of the key.
+func (s *FileBlobStore) Delete(_ context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// S3Client is the subset of the API of an S3-compatible object storage used by S3BlobStore.
+// It can be implemented by wrapping the client of AWS S3, MinIO and so on.
+// GetObject should return BlobNotFoundError if the object doesn't exist.
+type S3Client interface {
+	PutObject(ctx context.Context, bucket, key string, data []byte) error
+	GetObject(ctx context.Context, bucket, key string) ([]byte, error)
+	DeleteObject(ctx context.Context, bucket, key string) error
+}
+
+// S3BlobStore stores the blobs as the objects in a bucket of an S3-compatible object storage.
+// Use the lifecycle rules of the bucket to expire the blobs which are not deleted.
+type S3BlobStore struct {
+	client S3Client
+	bucket string
+	prefix string
+}
+
+// NewS3BlobStore creates an S3BlobStore, the keys of the objects are prefixed by prefix.
+func NewS3BlobStore(client S3Client, bucket, prefix string) *S3BlobStore {
+	return &S3BlobStore{
+		client: client,
+		bucket: bucket,
+		prefix: prefix,
+	}
+}
+
+// Put uploads the data as the object of the key.
+func (s *S3BlobStore) Put(ctx context.Context, key string, data []byte) error {
+	return s.client.PutObject(ctx, s.bucket, s.prefix+key, data)
+}
+
+// Get downloads the object of the key.
+func (s *S3BlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	return s.client.GetObject(ctx, s.bucket, s.prefix+key)
+}
+
+// Delete deletes the object of the key.
+func (s *S3BlobStore) Delete(ctx context.Context, key string) error {
+	return s.client.DeleteObject(ctx, s.bucket, s.prefix+key)
+}
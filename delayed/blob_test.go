@@ -0,0 +1,183 @@
+package delayed
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memoryS3Client struct {
+	lock    sync.Mutex
+	objects map[string][]byte
+}
+
+func (c *memoryS3Client) PutObject(_ context.Context, bucket, key string, data []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.objects == nil {
+		c.objects = map[string][]byte{}
+	}
+	c.objects[bucket+":"+key] = data
+	return nil
+}
+
+func (c *memoryS3Client) GetObject(_ context.Context, bucket, key string) ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	data, ok := c.objects[bucket+":"+key]
+	if !ok {
+		return nil, BlobNotFoundError
+	}
+	return data, nil
+}
+
+func (c *memoryS3Client) DeleteObject(_ context.Context, bucket, key string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.objects, bucket+":"+key)
+	return nil
+}
+
+func TestBlobStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "delayed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	client := &memoryS3Client{}
+	stores := []struct {
+		name  string
+		store BlobStore
+	}{
+		{"file", NewFileBlobStore(dir)},
+		{"s3", NewS3BlobStore(client, "bucket", "blobs/")},
+	}
+
+	ctx := context.Background()
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.store.Get(ctx, "test/1")
+			if err != BlobNotFoundError {
+				t.Fatalf("got error %v", err)
+			}
+
+			err = tt.store.Put(ctx, "test/1", []byte("data"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := tt.store.Get(ctx, "test/1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "data" {
+				t.Errorf("got data %q", data)
+			}
+
+			err = tt.store.Delete(ctx, "test/1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = tt.store.Get(ctx, "test/1")
+			if err != BlobNotFoundError {
+				t.Errorf("got error %v", err)
+			}
+			err = tt.store.Delete(ctx, "test/1")
+			if err != nil {
+				t.Errorf("got error %v", err)
+			}
+		})
+	}
+
+	if _, ok := client.objects["bucket:blobs/test/1"]; ok {
+		t.Error("the object is not deleted")
+	}
+
+	store := NewFileBlobStore(dir)
+	for _, key := range []string{"", "../test", "test/../../1", "/test"} {
+		if err = store.Put(ctx, key, nil); err != InvalidBlobKeyError {
+			t.Errorf("got error %v for key %q", err, key)
+		}
+	}
+}
+
+func TestQueueBlobStore(t *testing.T) {
+	store := NewS3BlobStore(&memoryS3Client{}, "bucket", "")
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2), QueueBlobStore(store, 100))
+	defer q.Clear()
+
+	var got []string
+	w := NewWorker(q)
+	w.RegisterHandlerAs("f", func(s string) error {
+		if strings.HasPrefix(s, "fail") {
+			return InvalidTaskError
+		}
+		got = append(got, s)
+		return nil
+	})
+
+	large := strings.Repeat("test", 100)
+	tasks := []*GoTask{
+		NewGoTask("f", "test"),
+		NewGoTask("f", large),
+		NewGoTask("f", "fail"+large[4:]),
+	}
+	for _, task := range tasks {
+		err := q.Enqueue(task)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tasks[0].raw.Blob != "" {
+		t.Errorf("the small task is offloaded to %s", tasks[0].raw.Blob)
+	}
+	for _, task := range tasks[1:] {
+		if task.raw.Blob != "test/"+task.raw.ID || task.raw.Payload != nil {
+			t.Errorf("got blob %q and payload %x", task.raw.Blob, task.raw.Payload)
+		}
+	}
+
+	incompatible, err := w.CheckQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(incompatible) != 0 {
+		t.Errorf("got incompatible task %#v", incompatible[0])
+	}
+
+	for range tasks {
+		task, err := q.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Execute(task)
+		q.Release()
+	}
+	if len(got) != 2 || got[0] != "test" || got[1] != large {
+		t.Errorf("got %d args", len(got))
+	}
+
+	ctx := context.Background()
+	_, err = store.Get(ctx, tasks[1].raw.Blob)
+	if err != BlobNotFoundError {
+		t.Errorf("the blob of the succeeded task is not deleted: %v", err)
+	}
+	_, err = store.Get(ctx, tasks[2].raw.Blob)
+	if err != nil {
+		t.Errorf("the blob of the failed task is deleted: %v", err)
+	}
+
+	task := NewGoTask("f", large)
+	err = q.Enqueue(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewQueue("test", NewRedisPool(redisAddr)).loadBlob(ctx, task)
+	if err != NoBlobStoreError {
+		t.Errorf("got error %v", err)
+	}
+}
@@ -0,0 +1,281 @@
+package delayed
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	revokedKeySuffix  = "_revoked"
+	controlKeySuffix  = "_control:"
+	canceledKeySuffix = "_canceled:"
+
+	cancelCommand = "cancel:"
+
+	// revokedExpiration is how long a revoked task ID is kept if no worker dequeues the task.
+	revokedExpiration = 24 * time.Hour
+
+	// cancelReplyTimeout is how long Cancel() waits for the worker to confirm it canceled the task, in seconds.
+	cancelReplyTimeout = 1
+)
+
+// controlChannel returns the pub/sub channel used to send commands to a worker.
+func (q *Queue) controlChannel(workerID string) string {
+	return q.name + controlKeySuffix + workerID
+}
+
+// Cancel cancels a task by its ID.
+// A pending task is removed from the queue.
+// If it's being processed, it's marked as revoked and its worker is notified to cancel the context of its handler.
+// It returns true if the task was removed, or its worker confirmed it canceled the running task.
+// If the worker is dead, the task is kept revoked so it will be skipped after it's requeued by RequeueLost(),
+// but false is returned since it's not confirmed.
+// A finished task can't be canceled, and a handler can only be canceled if it accepts a context.Context as its first argument.
+func (q *Queue) Cancel(taskID string) (ok bool, err error) {
+	if taskID == "" {
+		return
+	}
+
+	ok, err = q.Remove(taskID)
+	if err != nil || ok {
+		return
+	}
+
+	tasks, err := q.Processing()
+	if err != nil {
+		return
+	}
+	var workerID string
+	for id, task := range tasks {
+		if task.ID() == taskID {
+			workerID = id
+			break
+		}
+	}
+	if workerID == "" { // finished, or moved back to the queue (eg: requeued by RequeueLost()) after checking the queue
+		return q.Remove(taskID)
+	}
+
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	now := time.Now()
+	err = conn.Send("ZREMRANGEBYSCORE", q.revokedKey, "-inf", unixSeconds(now)) // removes the expired ones
+	if err != nil {
+		return
+	}
+	err = conn.Send("ZADD", q.revokedKey, unixSeconds(now.Add(revokedExpiration)), taskID)
+	if err != nil {
+		return
+	}
+	replyKey := q.name + canceledKeySuffix + taskID
+	err = conn.Send("DEL", replyKey) // clears the reply of an earlier cancellation
+	if err != nil {
+		return
+	}
+	err = conn.Send("PUBLISH", q.controlChannel(workerID), cancelCommand+taskID)
+	if err != nil {
+		return
+	}
+	replies, err := redis.Values(conn.Do(""))
+	if err != nil {
+		return
+	}
+	receivers, err := redis.Int(replies[len(replies)-1], nil)
+	if err != nil {
+		return
+	}
+	q.logger.Debug("Revoked task.", "queue", q.name, "task_id", taskID, "worker_id", workerID)
+	if receivers == 0 { // the worker is dead, the task is skipped if it's requeued
+		return
+	}
+
+	_, err = redis.ByteSlices(conn.Do("BLPOP", replyKey, cancelReplyTimeout))
+	if err == nil {
+		return true, nil
+	}
+	if err != redis.ErrNil {
+		return
+	}
+
+	// the worker is not running the task (eg: it's finished or about to be executed), so there is nothing to skip
+	_, err = conn.Do("ZREM", q.revokedKey, taskID)
+	return
+}
+
+// confirmCancel replies to Cancel() that the running task has been canceled.
+func (q *Queue) confirmCancel(taskID string) error {
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	key := q.name + canceledKeySuffix + taskID
+	err := conn.Send("RPUSH", key, 1)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Do("EXPIRE", key, cancelReplyTimeout)
+	return err
+}
+
+// skipRevoked removes the revoked mark of a dequeued task, and returns true if it was marked.
+// The task is released in this case.
+func (q *Queue) skipRevoked(conn redis.Conn, task *GoTask) bool {
+	removed, err := redis.Int(conn.Do("ZREM", q.revokedKey, task.raw.ID))
+	if err != nil { // executes the task rather than losing it
+		q.logger.Error("Failed to check revoked task.", "queue", q.name, "worker_id", q.workerID, "task_id", task.raw.ID, "error", err)
+		return false
+	}
+	if removed == 0 {
+		return false
+	}
+
+	q.logger.Info("Skipped revoked task.", "queue", q.name, "worker_id", q.workerID, "task_id", task.raw.ID, "func_path", task.raw.FuncPath)
+	_, err = conn.Do("HDEL", q.processingKey, q.workerID)
+	if err != nil {
+		q.logger.Error("Failed to release task.", "queue", q.name, "worker_id", q.workerID, "error", err)
+	}
+	q.emitTaskEvent(EventTaskCanceled, task)
+	return true
+}
+
+// unrevoke removes the revoked mark of a canceled task after it's finished.
+func (q *Queue) unrevoke(taskID string) error {
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("ZREM", q.revokedKey, taskID)
+	return err
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// runningTask stores the cancellation state of the task being executed by a worker.
+type runningTask struct {
+	mu       sync.Mutex
+	id       string
+	cancel   context.CancelFunc
+	canceled bool
+}
+
+func (r *runningTask) start(id string, cancel context.CancelFunc) {
+	r.mu.Lock()
+	r.id = id
+	r.cancel = cancel
+	r.canceled = false
+	r.mu.Unlock()
+}
+
+// end clears the running task, and returns whether it was canceled.
+func (r *runningTask) end() (canceled bool) {
+	r.mu.Lock()
+	if r.cancel != nil {
+		r.cancel()
+	}
+	canceled = r.canceled
+	r.id = ""
+	r.cancel = nil
+	r.canceled = false
+	r.mu.Unlock()
+	return
+}
+
+// cancelTask cancels the context of the running task if its ID matches.
+func (r *runningTask) cancelTask(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cancel == nil || r.id != id {
+		return false
+	}
+	r.cancel()
+	r.canceled = true
+	return true
+}
+
+// listen subscribes the control channel of the worker in a goroutine, and handles the commands until stop is called.
+// It resubscribes if the connection is broken.
+func (w *Worker) listen() (stop func()) {
+	var mu sync.Mutex
+	var psc *redis.PubSubConn
+	stopped := make(chan struct{})
+	done := make(chan struct{})
+	channel := w.queue.controlChannel(w.id)
+
+	go func() {
+		defer close(done)
+
+		for {
+			conn := w.queue.redis.Get()
+			c := &redis.PubSubConn{Conn: conn}
+			err := c.Subscribe(channel)
+			if err == nil {
+				mu.Lock()
+				select {
+				case <-stopped:
+					mu.Unlock()
+					conn.Close()
+					return
+				default:
+					psc = c
+				}
+				mu.Unlock()
+
+				err = w.receive(c)
+				mu.Lock()
+				psc = nil
+				mu.Unlock()
+			}
+			conn.Close()
+			if err == nil {
+				return
+			}
+
+			w.logger.Error("Failed to receive control commands.", "queue", w.queue.name, "worker_id", w.id, "error", err)
+			select {
+			case <-stopped:
+				return
+			case <-time.After(defaultSleepTime):
+			}
+		}
+	}()
+
+	return func() {
+		mu.Lock()
+		close(stopped)
+		if psc != nil {
+			psc.Unsubscribe()
+		}
+		mu.Unlock()
+		<-done
+	}
+}
+
+// receive handles the commands until unsubscribed.
+func (w *Worker) receive(psc *redis.PubSubConn) error {
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			if strings.HasPrefix(string(v.Data), cancelCommand) {
+				taskID := string(v.Data[len(cancelCommand):])
+				if w.running.cancelTask(taskID) {
+					w.logger.Info("Canceling task.", "queue", w.queue.name, "worker_id", w.id, "task_id", taskID)
+					if err := w.queue.confirmCancel(taskID); err != nil {
+						w.logger.Error("Failed to confirm canceling task.", "queue", w.queue.name, "worker_id", w.id, "task_id", taskID, "error", err)
+					}
+				}
+			}
+		case redis.Subscription:
+			if v.Count == 0 {
+				return nil
+			}
+		case error:
+			return v
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package delayed
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func Sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+func TestQueueCancelPending(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr))
+	defer q.Clear()
+
+	task := NewGoTaskOfFunc(Sleep, time.Second)
+	q.Enqueue(task)
+
+	ok, err := q.Cancel(task.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("failed to cancel the task")
+	}
+	count, err := q.Len()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("got %d tasks", count)
+	}
+}
+
+func TestQueueCancelRevoked(t *testing.T) {
+	recorder := &eventRecorder{}
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2), OnEvent(recorder.handle))
+	defer q.Clear()
+	NewWorker(q)
+
+	task := NewGoTaskOfFunc(Sleep, time.Second)
+	q.Enqueue(task)
+	q.Dequeue() // the worker is not alive, so the task is lost
+
+	ok, err := q.Cancel(task.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("notified a dead worker")
+	}
+
+	count, err := q.RequeueLost()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("requeued %d tasks", count)
+	}
+
+	recorder.events = nil
+	dequeued, err := q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dequeued != nil {
+		t.Fatalf("dequeued a revoked task %#v", dequeued)
+	}
+	assertEventTypes(t, recorder.types(), EventTaskCanceled)
+
+	stats, err := q.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Len != 0 || stats.Processing != 0 {
+		t.Errorf("got %+v", stats)
+	}
+	conn := q.redis.Get()
+	defer conn.Close()
+	count, err = redis.Int(conn.Do("ZCARD", q.revokedKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("got %d revoked tasks", count)
+	}
+}
+
+func TestQueueCancelRunning(t *testing.T) {
+	events := make(chan *Event, 10)
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2), OnEvent(func(e *Event) {
+		if e.Type == EventTaskDequeued || e.Type == EventTaskCanceled || e.Type == EventTaskSucceeded {
+			events <- e
+		}
+	}))
+	defer q.Clear()
+	w := NewWorker(q)
+	w.RegisterHandlers(Sleep)
+	done := make(chan struct{})
+	go func() {
+		w.Run()
+		close(done)
+	}()
+	defer func() { // waits for the worker to stop, or it may dequeue tasks of other tests
+		w.Stop()
+		<-done
+	}()
+
+	task := NewGoTaskOfFunc(Sleep, time.Minute)
+	q.Enqueue(task)
+	select {
+	case e := <-events:
+		if e.Type != EventTaskDequeued {
+			t.Fatalf("got event %v", e.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the task is not dequeued")
+	}
+
+	var ok bool
+	var err error
+	for i := 0; i < 100 && !ok; i++ { // the worker may not have subscribed its control channel
+		ok, err = q.Cancel(task.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if !ok {
+		t.Fatal("failed to cancel the task")
+	}
+
+	select {
+	case e := <-events:
+		if e.Type != EventTaskCanceled || e.TaskID != task.ID() || e.Error != context.Canceled.Error() {
+			t.Fatalf("got event %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the task is not canceled")
+	}
+}
+
+func TestQueueCancelNotRunning(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2))
+	defer q.Clear()
+	w := NewWorker(q)
+	stop := w.listen()
+	defer stop()
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	ok, err := q.Cancel("unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("canceled an unknown task")
+	}
+
+	task := NewGoTaskOfFunc(Sleep, time.Second)
+	q.Enqueue(task)
+	q.Dequeue() // dequeued by the worker, but not executed
+	defer q.Release()
+
+	count := 1
+	for i := 0; i < 100 && count > 0; i++ { // the revoked mark is kept until the worker has subscribed its control channel
+		ok, err = q.Cancel(task.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			t.Fatal("canceled a task not running")
+		}
+		count, err = redis.Int(conn.Do("ZCARD", q.revokedKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if count != 0 {
+		t.Errorf("got %d revoked tasks", count)
+	}
+}
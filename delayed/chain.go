@@ -0,0 +1,240 @@
+package delayed
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strconv"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/shamaton/msgpack/v2"
+)
+
+const (
+	chordKeySuffix = "_chord:"
+
+	// chordExpiration is how long the results of an incomplete chord are kept, in seconds.
+	chordExpiration = 7 * 24 * 3600
+
+	// chordClaimField marks the chord is claimed by a worker to enqueue its callback, so the callback is enqueued only once.
+	chordClaimField = "callback"
+
+	// KEYS: chord_key
+	// ARGV: index, result, size, expiration
+	// returns: index1, result1, index2, result2... if the chord is completed and claimed by the caller, or nil
+	// The results are deleted by the worker after the callback is enqueued, so they aren't lost if it fails.
+	completeChordScript = `redis.call('hset', KEYS[1], ARGV[1], ARGV[2])
+redis.call('expire', KEYS[1], ARGV[4])
+if redis.call('hlen', KEYS[1]) < tonumber(ARGV[3]) then
+    return nil
+end
+local results = redis.call('hgetall', KEYS[1])
+if redis.call('hsetnx', KEYS[1], '` + chordClaimField + `', '1') == 0 then
+    return nil
+end
+return results`
+)
+
+var completeChord = redis.NewScript(1, completeChordScript)
+
+var EmptyWorkflowError = errors.New("Empty workflow")
+
+// RawWorkflow stores the follow-up tasks of a GoTask, which are enqueued to the same queue after it succeeded.
+type RawWorkflow struct {
+	Next       [][]byte // serialized tasks, those without arg receive the result of this task
+	ChordID    string   // the ID of the chord this task belongs to
+	ChordIndex int      // the index of this task in the chord
+	ChordSize  int      // the count of the tasks in the chord
+	Callback   []byte   // the serialized callback of the chord, which receives the results of all the tasks in the chord as a slice
+	ID         string   // the ID of the DAG workflow this task belongs to, see NewWorkflow()
+	Node       string   // the name of this task in the DAG workflow
+}
+
+func (t *GoTask) workflow() *RawWorkflow {
+	if t.raw.Workflow == nil {
+		t.raw.Workflow = &RawWorkflow{}
+	}
+	t.data = nil // should be serialized again
+	return t.raw.Workflow
+}
+
+// Chain links the tasks, each task is enqueued after the previous one succeeded.
+// A task without arg receives the result of the previous task as its arg,
+// multiple results (except the last error) are passed as multiple args.
+// It returns the first task, which should be enqueued.
+// The tasks shouldn't be modified after chained.
+func Chain(tasks ...*GoTask) (*GoTask, error) {
+	if len(tasks) == 0 {
+		return nil, EmptyWorkflowError
+	}
+
+	for i := len(tasks) - 1; i > 0; i-- {
+		data, err := tasks[i].Serialize()
+		if err != nil {
+			return nil, err
+		}
+		w := tasks[i-1].workflow()
+		w.Next = append(w.Next, data)
+	}
+	return tasks[0], nil
+}
+
+// Chord makes the tasks a group, the callback is enqueued after all of them succeeded.
+// The callback receives the results of the tasks as a slice in the same order, it's nil for a task without result.
+// It returns the tasks, which should be enqueued to the same queue.
+// A failed task can be requeued by RequeueFailed() to complete the chord.
+// The results are kept until the callback is enqueued, so the chord can also be completed by executing a task of it again
+// if the callback failed to be enqueued. The callback is enqueued only once even if a task is executed again after that.
+func Chord(group []*GoTask, callback *GoTask) ([]*GoTask, error) {
+	if len(group) == 0 {
+		return nil, EmptyWorkflowError
+	}
+
+	data, err := callback.Serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	id := RandHexString(8)
+	for i, task := range group {
+		w := task.workflow()
+		w.ChordID = id
+		w.ChordIndex = i
+		w.ChordSize = len(group)
+		w.Callback = data
+	}
+	return group, nil
+}
+
+// encodeResult serializes the results of a handler except the last error.
+// It returns nil if there are no results.
+func encodeResult(result []reflect.Value) ([]byte, error) {
+	if len(result) > 0 && result[len(result)-1].Type() == errorType {
+		result = result[:len(result)-1]
+	}
+
+	switch len(result) {
+	case 0:
+		return nil, nil
+	case 1:
+		return msgpack.MarshalAsArray(result[0].Interface())
+	default:
+		values := make([]interface{}, len(result))
+		for i, r := range result {
+			values[i] = r.Interface()
+		}
+		return msgpack.MarshalAsArray(values)
+	}
+}
+
+// appendArrayHeader appends the MessagePack header of an array with n elements.
+func appendArrayHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x90|byte(n))
+	case n <= 0xffff:
+		b = append(b, 0xdc, 0, 0)
+		binary.BigEndian.PutUint16(b[len(b)-2:], uint16(n))
+		return b
+	default:
+		b = append(b, 0xdd, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(b[len(b)-4:], uint32(n))
+		return b
+	}
+}
+
+// hasArg returns false if the payload is empty or nil.
+func hasArg(payload []byte) bool {
+	return len(payload) > 0 && !(len(payload) == 1 && payload[0] == 0xc0)
+}
+
+// continueWorkflow records the result of a finished task, and enqueues its follow-up tasks if it succeeded.
+func (w *Worker) continueWorkflow(t *GoTask, result []reflect.Value, taskErr error) error {
+	wf := t.raw.Workflow
+	if taskErr != nil {
+		if wf.ID != "" {
+			return w.queue.failNode(wf, taskErr)
+		}
+		return nil
+	}
+
+	payload, err := encodeResult(result)
+	if err != nil {
+		return err
+	}
+
+	if wf.ID != "" {
+		return w.queue.completeNode(wf, payload)
+	}
+
+	for _, data := range wf.Next {
+		next, err := DeserializeGoTask(data)
+		if err != nil {
+			return err
+		}
+		err = w.enqueueFollowUp(next, payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	if wf.ChordID != "" {
+		return w.completeChord(wf, payload)
+	}
+	return nil
+}
+
+// enqueueFollowUp enqueues a follow-up task, it receives the payload if it has no arg.
+func (w *Worker) enqueueFollowUp(t *GoTask, payload []byte) error {
+	if len(payload) > 0 && !hasArg(t.raw.Payload) && t.raw.Blob == "" {
+		t.setPayload(payload)
+	}
+	return w.queue.EnqueueContext(context.Background(), t)
+}
+
+func (w *Worker) completeChord(wf *RawWorkflow, payload []byte) error {
+	conn := w.queue.redis.Get()
+	defer conn.Close()
+
+	if payload == nil {
+		payload = []byte{0xc0} // nil
+	}
+	key := w.queue.name + chordKeySuffix + wf.ChordID
+	reply, err := redis.ByteSlices(completeChord.Do(conn, key, wf.ChordIndex, payload, wf.ChordSize, chordExpiration))
+	if err != nil {
+		if err == redis.ErrNil { // not completed
+			err = nil
+		}
+		return err
+	}
+
+	results := make([][]byte, wf.ChordSize)
+	for i := 0; i+1 < len(reply); i += 2 {
+		index, err := strconv.Atoi(string(reply[i]))
+		if err != nil || index < 0 || index >= wf.ChordSize {
+			return InvalidRedisReplyError
+		}
+		results[index] = reply[i+1]
+	}
+
+	args := appendArrayHeader(nil, wf.ChordSize)
+	for _, r := range results {
+		args = append(args, r...)
+	}
+
+	callback, err := DeserializeGoTask(wf.Callback)
+	if err != nil {
+		return err
+	}
+	callback.setPayload(args)
+	err = w.queue.EnqueueContext(context.Background(), callback)
+	if err != nil {
+		if _, e := conn.Do("HDEL", key, chordClaimField); e != nil { // so it can be claimed again
+			w.logger.Error("Failed to unclaim chord.", "queue", w.queue.name, "worker_id", w.id, "chord_id", wf.ChordID, "error", e)
+		}
+		return err
+	}
+	_, err = conn.Do("DEL", key)
+	return err
+}
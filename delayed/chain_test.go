@@ -0,0 +1,254 @@
+package delayed
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func divMod(a, b int) (int, int, error) {
+	if b == 0 {
+		return 0, 0, errors.New("division by zero")
+	}
+	return a / b, a % b, nil
+}
+
+func sum(a ...int) int {
+	s := 0
+	for _, n := range a {
+		s += n
+	}
+	return s
+}
+
+func TestAppendArrayHeader(t *testing.T) {
+	tests := []struct {
+		n    int
+		want []byte
+	}{
+		{0, []byte{0x90}},
+		{15, []byte{0x9f}},
+		{16, []byte{0xdc, 0, 16}},
+		{0xffff, []byte{0xdc, 0xff, 0xff}},
+		{0x10000, []byte{0xdd, 0, 1, 0, 0}},
+	}
+	for _, tt := range tests {
+		got := appendArrayHeader(nil, tt.n)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("appendArrayHeader(%d) = %x, want %x", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	_, err := Chain()
+	if err != EmptyWorkflowError {
+		t.Fatalf("got error %v", err)
+	}
+
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2))
+	defer q.Clear()
+	w := NewWorker(q)
+	w.RegisterHandlers(divMod, sum)
+
+	first := NewGoTaskOfFunc(divMod, 7, 2)
+	task, err := Chain(first, NewGoTaskOfFunc(sum), NewGoTaskOfFunc(sum, 1, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task != first {
+		t.Fatal("the first task is not returned")
+	}
+	err = q.Enqueue(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// divMod(7, 2) -> sum(3, 1) -> sum(1, 2)
+	wants := []interface{}{[]int{7, 2}, []int{3, 1}, []int{1, 2}}
+	for i, want := range wants {
+		task, err := q.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task == nil {
+			t.Fatalf("task %d is not enqueued", i)
+		}
+		var args []int
+		err = task.DecodeArg(&args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(args, want) {
+			t.Fatalf("task %d got args %v, want %v", i, args, want)
+		}
+		w.Execute(task)
+		q.Release()
+	}
+
+	task, err = q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task != nil {
+		t.Fatalf("got unexpected task %#v", task)
+	}
+
+	// the follow-up tasks are not enqueued if failed
+	task, _ = Chain(NewGoTaskOfFunc(divMod, 1, 0), NewGoTaskOfFunc(sum))
+	q.Enqueue(task)
+	task, _ = q.Dequeue()
+	w.Execute(task)
+	q.Release()
+	n, err := q.Len()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("got %d tasks after failed", n)
+	}
+}
+
+func TestChord(t *testing.T) {
+	_, err := Chord(nil, NewGoTaskOfFunc(sum))
+	if err != EmptyWorkflowError {
+		t.Fatalf("got error %v", err)
+	}
+
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2))
+	defer q.Clear()
+	w := NewWorker(q)
+	w.RegisterHandlers(sum)
+
+	group := []*GoTask{NewGoTaskOfFunc(sum, 1, 2), NewGoTaskOfFunc(sum, 3, 0), NewGoTaskOfFunc(sum, 4, 5, 6)}
+	tasks, err := Chord(group, NewGoTaskOfFunc(sum))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range tasks {
+		q.Enqueue(task)
+	}
+
+	conn := q.redis.Get()
+	defer conn.Close()
+	key := q.name + chordKeySuffix + group[0].raw.Workflow.ChordID
+	defer conn.Do("DEL", key)
+
+	// executes in reversed order
+	for i := len(tasks) - 1; i >= 0; i-- {
+		w.Execute(tasks[i])
+		n, err := q.Len()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 && n != len(tasks) {
+			t.Fatalf("the callback is enqueued before the chord is completed")
+		}
+	}
+
+	for range tasks {
+		q.Dequeue()
+		q.Release()
+	}
+	callback, err := q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if callback == nil {
+		t.Fatal("the callback is not enqueued")
+	}
+	var results []int
+	err = callback.DecodeArg(&results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(results, []int{3, 3, 15}) {
+		t.Fatalf("got results %v", results)
+	}
+	q.Release()
+
+	exists, err := conn.Do("EXISTS", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists.(int64) != 0 {
+		t.Error("the results of the chord are not deleted")
+	}
+}
+
+func TestChordCallbackFailure(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2), AllowFuncPaths("f"))
+	defer q.Clear()
+	w := NewWorker(q)
+	w.RegisterHandlerAs("f", sum)
+
+	tasks, err := Chord([]*GoTask{NewGoTask("f", 1, 2), NewGoTask("f", 3, 0)}, NewGoTask("g"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range tasks {
+		_, err = task.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Execute(task) // the callback is disallowed
+	}
+
+	conn := q.redis.Get()
+	defer conn.Close()
+	key := q.name + chordKeySuffix + tasks[0].raw.Workflow.ChordID
+	defer conn.Do("DEL", key)
+	n, err := redis.Int(conn.Do("HLEN", key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 { // not claimed after failing to enqueue the callback
+		t.Fatalf("got %d results", n)
+	}
+
+	q.allowedFuncPaths["g"] = true
+	_, err = conn.Do("HSET", key, chordClaimField, "1") // claimed by another worker
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Execute(tasks[0])
+	n, err = q.Len()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("enqueued the callback of a claimed chord")
+	}
+	_, err = conn.Do("HDEL", key, chordClaimField)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.Execute(tasks[1]) // completes the chord again
+	callback, err := q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if callback == nil || callback.raw.FuncPath != "g" {
+		t.Fatalf("got callback %v", callback)
+	}
+	q.Release()
+	var results []int
+	err = callback.DecodeArg(&results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(results, []int{3, 3}) {
+		t.Errorf("got results %v", results)
+	}
+	n, err = redis.Int(conn.Do("EXISTS", key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Error("the results of the chord are not deleted")
+	}
+}
@@ -0,0 +1,152 @@
+package delayed
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+
+	"github.com/shamaton/msgpack/v2"
+)
+
+var UnknownCodecError = errors.New("Unknown codec")
+
+// Codec serializes the args of a GoTask into its payload.
+// Its name is recorded in the task, so the worker decodes the payload by the same codec.
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type msgpackArrayCodec struct{}
+
+func (msgpackArrayCodec) Name() string                          { return "msgpack-array" }
+func (msgpackArrayCodec) Marshal(v interface{}) ([]byte, error) { return msgpack.MarshalAsArray(v) }
+func (msgpackArrayCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.UnmarshalAsArray(data, v)
+}
+
+type msgpackMapCodec struct{}
+
+func (msgpackMapCodec) Name() string                               { return "msgpack-map" }
+func (msgpackMapCodec) Marshal(v interface{}) ([]byte, error)      { return msgpack.Marshal(v) }
+func (msgpackMapCodec) Unmarshal(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                               { return "json" }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+var (
+	// MsgpackArrayCodec serializes structs as MessagePack arrays of their fields.
+	// It's the most compact, but the fields of the structs shouldn't be reordered or removed while their tasks are in the queues.
+	// It's the only codec supported by the versions before codecs were added.
+	MsgpackArrayCodec Codec = msgpackArrayCodec{}
+	// MsgpackMapCodec serializes structs as MessagePack maps keyed by their field names (or msgpack tags),
+	// so fields can be added, reordered or removed while their tasks are in the queues.
+	MsgpackMapCodec Codec = msgpackMapCodec{}
+	// JSONCodec serializes args by encoding/json.
+	JSONCodec Codec = jsonCodec{}
+	// GobCodec serializes args by encoding/gob, it can't serialize nil values.
+	GobCodec Codec = gobCodec{}
+
+	// DefaultCodec is the codec of the GoTasks without codecs set by GoTask.SetCodec() or QueueCodec().
+	// Set it to MsgpackArrayCodec if the tasks are executed by the workers of the versions before codecs were added.
+	DefaultCodec = MsgpackMapCodec
+
+	codecsLock sync.RWMutex
+	codecs     = map[string]Codec{}
+)
+
+func init() {
+	RegisterCodec(MsgpackArrayCodec)
+	RegisterCodec(MsgpackMapCodec)
+	RegisterCodec(JSONCodec)
+	RegisterCodec(GobCodec)
+}
+
+// RegisterCodec registers a codec by its name, so the tasks serialized by it can be executed.
+// The built-in codecs are registered by default.
+func RegisterCodec(c Codec) {
+	codecsLock.Lock()
+	codecs[c.Name()] = c
+	codecsLock.Unlock()
+}
+
+// QueueCodec sets the default codec of the GoTasks enqueued to the queue, it's DefaultCodec if not set.
+// It's ignored for tasks with codecs set by GoTask.SetCodec(), and for deserialized tasks.
+func QueueCodec(c Codec) QueueOption {
+	return func(q *Queue) {
+		q.codec = c
+	}
+}
+
+// codecOf returns the codec by its name recorded in a task, the empty name means MsgpackArrayCodec.
+func codecOf(name string) (Codec, error) {
+	if name == "" {
+		return MsgpackArrayCodec, nil
+	}
+
+	codecsLock.RLock()
+	c, ok := codecs[name]
+	codecsLock.RUnlock()
+	if !ok {
+		return nil, UnknownCodecError
+	}
+	return c, nil
+}
+
+// codecName returns the name of a codec recorded in a task, it's empty for MsgpackArrayCodec to keep compatible with old versions.
+func codecName(c Codec) string {
+	if c == nil || c == MsgpackArrayCodec {
+		return ""
+	}
+	return c.Name()
+}
+
+// argsStruct converts multiple args into a struct, whose fields are named F0, F1... as the handler decodes them.
+func argsStruct(args []interface{}) interface{} {
+	fields := make([]reflect.StructField, len(args))
+	for i, arg := range args {
+		var t reflect.Type
+		if arg == nil {
+			t = reflect.TypeOf((*interface{})(nil)).Elem()
+		} else {
+			t = reflect.TypeOf(arg)
+		}
+		fields[i] = reflect.StructField{
+			Name: "F" + strconv.Itoa(i),
+			Type: t,
+		}
+	}
+
+	v := reflect.New(reflect.StructOf(fields)).Elem()
+	for i, arg := range args {
+		if arg != nil {
+			v.Field(i).Set(reflect.ValueOf(arg))
+		}
+	}
+	return v.Interface()
+}
@@ -0,0 +1,126 @@
+package delayed
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type unregisteredCodec struct {
+	Codec
+}
+
+func (unregisteredCodec) Name() string {
+	return "unregistered"
+}
+
+type reorderedArg struct {
+	B string
+	A int
+}
+
+func TestCodecs(t *testing.T) {
+	for _, c := range []Codec{MsgpackArrayCodec, MsgpackMapCodec, JSONCodec, GobCodec} {
+		t.Run(c.Name(), func(t *testing.T) {
+			tests := []struct {
+				fn   interface{}
+				task *GoTask
+				want int
+			}{
+				{f1, NewGoTask("f1", testArg{A: 1, B: "test"}), 5},
+				{f2, NewGoTask("f2", &testArg{A: 1, B: "test"}), 5},
+				{f3, NewGoTask("f3", 3), 3},
+				{f5, NewGoTask("f5"), 0},
+				{f6, NewGoTask("f6", 1, 2), 3},
+				{f9, NewGoTask("f9", []int{1, 2}, testArg{A: 1, B: "test"}), 8},
+			}
+			for _, tt := range tests {
+				tt.task.SetCodec(c)
+				data, err := tt.task.Serialize()
+				if err != nil {
+					t.Fatal(err)
+				}
+				task, err := DeserializeGoTask(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				codec, err := codecOf(task.raw.Codec)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if codec != c {
+					t.Fatalf("got codec %s", codec.Name())
+				}
+
+				result, err := NewHandler(tt.fn).call(context.Background(), codec, task.raw.Payload)
+				if err != nil {
+					t.Fatalf("failed to call %s: %v", tt.task.FuncPath(), err)
+				}
+				if got := int(result[0].Int()); got != tt.want {
+					t.Errorf("%s got %d, want %d", tt.task.FuncPath(), got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCodecFieldOrder(t *testing.T) {
+	task := NewGoTask("f1", testArg{A: 1, B: "test"})
+	task.SetCodec(MsgpackMapCodec)
+	_, err := task.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var arg reorderedArg
+	err = task.DecodeArg(&arg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if arg.A != 1 || arg.B != "test" {
+		t.Errorf("got arg %#v", arg)
+	}
+
+	data, err := json.Marshal(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"id":"`+task.ID()+`","func_path":"f1","payload":{"A":1,"B":"test"},"codec":"msgpack-map"}` {
+		t.Errorf("got JSON %s", data)
+	}
+}
+
+func TestQueueCodec(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2), QueueCodec(JSONCodec))
+	defer q.Clear()
+	r := &eventRecorder{}
+	w := NewWorker(q)
+	w.RegisterHandlers(f6)
+
+	task := NewGoTaskOfFunc(f6, 1, 2)
+	gobTask := NewGoTaskOfFunc(f6, 3, 4)
+	gobTask.SetCodec(GobCodec)
+	unknownTask := NewGoTaskOfFunc(f6, 5, 6)
+	unknownTask.SetCodec(unregisteredCodec{JSONCodec})
+	for _, task := range []*GoTask{task, gobTask, unknownTask} {
+		err := q.Enqueue(task)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if task.raw.Codec != "json" || gobTask.raw.Codec != "gob" {
+		t.Fatalf("got codecs %s, %s", task.raw.Codec, gobTask.raw.Codec)
+	}
+
+	q.eventHandlers = []EventHandler{r.handle}
+	for i := 0; i < 3; i++ {
+		task, err := q.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Execute(task)
+		q.Release()
+	}
+	assertEventTypes(t, r.types(), EventTaskDequeued, EventTaskSucceeded, EventTaskDequeued, EventTaskSucceeded, EventTaskDequeued, EventTaskFailed)
+}
@@ -0,0 +1,221 @@
+package delayed
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/shamaton/msgpack/v2"
+)
+
+// compressedExtType is the MessagePack extension type of the compressed tasks.
+const compressedExtType = 1
+
+var (
+	UnknownCompressionError = errors.New("Unknown compression")
+	DecompressionLimitError = errors.New("Decompressed data exceeds the limit")
+)
+
+// MaxDecompressedSize is the max size in bytes of a decompressed task, so a small task can't exhaust the memory of the worker.
+// It should be changed before dequeuing tasks.
+var MaxDecompressedSize = 64 << 20
+
+// Compressor compresses the serialized tasks.
+// Its name is recorded in the compressed task, so the task is decompressed by the same compressor.
+// Decompress() should return DecompressionLimitError rather than decompress more than MaxDecompressedSize bytes.
+type Compressor interface {
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string { return "gzip" }
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	data, err = ioutil.ReadAll(io.LimitReader(r, int64(MaxDecompressedSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxDecompressedSize {
+		return nil, DecompressionLimitError
+	}
+	return data, nil
+}
+
+var (
+	// GzipCompressor compresses tasks by gzip.
+	// Other compressors (eg: zstd and snappy) are provided by the optional compress module.
+	GzipCompressor Compressor = gzipCompressor{}
+
+	compressorsLock sync.RWMutex
+	compressors     = map[string]Compressor{}
+)
+
+func init() {
+	RegisterCompressor(GzipCompressor)
+}
+
+// RegisterCompressor registers a compressor by its name, so the tasks compressed by it can be decompressed.
+// GzipCompressor is registered by default.
+func RegisterCompressor(c Compressor) {
+	compressorsLock.Lock()
+	compressors[c.Name()] = c
+	compressorsLock.Unlock()
+}
+
+// QueueCompression compresses the tasks enqueued to the queue by the compressor if their serialized data are larger than threshold bytes.
+// It's ignored for tasks with compressors set by SetCompression(), and for serialized tasks.
+// The tasks are decompressed transparently when they are deserialized, but workers of older versions can't execute them.
+func QueueCompression(c Compressor, threshold int) QueueOption {
+	return func(q *Queue) {
+		q.compressor = c
+		q.compressionThreshold = threshold
+	}
+}
+
+// rawCompressedTask store the fields of a compressed task, which is serialized as a MessagePack extension.
+type rawCompressedTask struct {
+	Compression string // the name of the compressor
+	Data        []byte // the compressed data of the serialized task
+}
+
+// compress compresses the serialized task if it's larger than threshold bytes, and the compressed one is smaller.
+func compress(data []byte, c Compressor, threshold int) ([]byte, error) {
+	if c == nil || len(data) <= threshold {
+		return data, nil
+	}
+
+	compressed, err := c.Compress(data)
+	if err != nil {
+		return nil, err
+	}
+	b, err := marshalExt(compressedExtType, &rawCompressedTask{
+		Compression: c.Name(),
+		Data:        compressed,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(b) >= len(data) {
+		return data, nil
+	}
+	return b, nil
+}
+
+// decompress decompresses a serialized task, and returns its compressor.
+// It returns the data and a nil compressor if the task is not compressed.
+// The signature of a signed task is stripped without being verified, the dequeued tasks are verified before decompressing, see QueueSigning().
+func decompress(data []byte) ([]byte, Compressor, error) {
+	data, err := unsign(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	extType, body, err := unmarshalExt(data)
+	if err != nil || body == nil {
+		return data, nil, err
+	}
+	if extType != compressedExtType {
+		return nil, nil, InvalidTaskError
+	}
+
+	var raw rawCompressedTask
+	err = msgpack.UnmarshalAsArray(body, &raw)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	compressorsLock.RLock()
+	c, ok := compressors[raw.Compression]
+	compressorsLock.RUnlock()
+	if !ok {
+		return nil, nil, UnknownCompressionError
+	}
+
+	data, err = c.Decompress(raw.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, c, nil
+}
+
+// marshalExt serializes v as an array, and wraps it in a MessagePack extension of the type.
+func marshalExt(extType byte, v interface{}) ([]byte, error) {
+	body, err := msgpack.MarshalAsArray(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var b []byte
+	n := len(body)
+	switch {
+	case n <= 0xff:
+		b = append(make([]byte, 0, n+3), 0xc7, byte(n))
+	case n <= 0xffff:
+		b = append(make([]byte, 0, n+4), 0xc8, 0, 0)
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+	default:
+		b = append(make([]byte, 0, n+6), 0xc9, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+	}
+	b = append(b, extType)
+	return append(b, body...), nil
+}
+
+// unmarshalExt returns the type and the body of a MessagePack extension.
+// It returns a nil body if data is not an extension, eg: an uncompressed task.
+func unmarshalExt(data []byte) (extType byte, body []byte, err error) {
+	if len(data) == 0 {
+		return
+	}
+
+	var n, offset int
+	switch data[0] {
+	case 0xc7: // ext 8
+		if len(data) < 3 {
+			return 0, nil, InvalidTaskError
+		}
+		n, offset = int(data[1]), 3
+	case 0xc8: // ext 16
+		if len(data) < 4 {
+			return 0, nil, InvalidTaskError
+		}
+		n, offset = int(binary.BigEndian.Uint16(data[1:])), 4
+	case 0xc9: // ext 32
+		if len(data) < 6 {
+			return 0, nil, InvalidTaskError
+		}
+		n, offset = int(binary.BigEndian.Uint32(data[1:])), 6
+	default:
+		return
+	}
+	if len(data) != offset+n {
+		return 0, nil, InvalidTaskError
+	}
+	return data[offset-1], data[offset:], nil
+}
@@ -0,0 +1,153 @@
+package delayed
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type unregisteredCompressor struct {
+	Compressor
+}
+
+func (unregisteredCompressor) Name() string {
+	return "unregistered"
+}
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat("test", 100)
+	tests := []struct {
+		name       string
+		task       Task
+		compressed bool
+	}{
+		{"small go task", NewGoTask("f", "test"), false},
+		{"large go task", NewGoTask("f", large), true},
+		{"small py task", NewPyTask("f", []string{"test"}, nil), false},
+		{"large py task", NewPyTask("f", []string{large}, nil), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			switch task := tt.task.(type) {
+			case *GoTask:
+				task.SetCompression(GzipCompressor, 100)
+			case *PyTask:
+				task.SetCompression(GzipCompressor, 100)
+			}
+			data, err := tt.task.Serialize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if compressed := data[0] >= 0xc7 && data[0] <= 0xc9; compressed != tt.compressed {
+				t.Fatalf("compressed: %v", compressed)
+			}
+			if tt.compressed && len(data) >= len(large) {
+				t.Errorf("compressed to %d bytes", len(data))
+			}
+
+			task, err := DeserializeTask(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch task := task.(type) {
+			case *GoTask:
+				var arg string
+				err = task.DecodeArg(&arg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if arg != large && arg != "test" {
+					t.Errorf("got arg %q", arg)
+				}
+				if tt.compressed != (task.compressor == GzipCompressor) {
+					t.Errorf("got compressor %v", task.compressor)
+				}
+
+				task.SetHeader("key", "value") // compressed again
+				data2, err := task.Serialize()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if data2[0] != data[0] {
+					t.Errorf("got data %x", data2)
+				}
+			case *PyTask:
+				args, ok := task.raw.Args.([]interface{})
+				if !ok || len(args) != 1 || (args[0] != large && args[0] != "test") {
+					t.Errorf("got args %#v", task.raw.Args)
+				}
+			default:
+				t.Fatalf("got task %#v", task)
+			}
+		})
+	}
+
+	task := NewGoTask("f", large)
+	task.SetCompression(unregisteredCompressor{GzipCompressor}, 0)
+	data, err := task.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = DeserializeGoTask(data)
+	if err != UnknownCompressionError {
+		t.Errorf("got error %v", err)
+	}
+
+	defer func(size int) { MaxDecompressedSize = size }(MaxDecompressedSize)
+	MaxDecompressedSize = len(large)
+	task = NewGoTask("f", large) // the serialized task is a bit larger than the arg
+	task.SetCompression(GzipCompressor, 0)
+	data, err = task.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = DeserializeGoTask(data)
+	if err != DecompressionLimitError {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestQueueCompression(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2), QueueCompression(GzipCompressor, 100))
+	defer q.Clear()
+
+	var got []int
+	w := NewWorker(q)
+	w.RegisterHandlerAs("f", func(a []int) {
+		got = append(got, len(a))
+	})
+
+	large := make([]int, 1000)
+	tasks := []Task{
+		NewGoTask("f", []int{1}),
+		NewGoTask("f", large),
+		NewPyTask("f", []interface{}{large}, nil),
+	}
+	for _, task := range tasks {
+		err := q.Enqueue(task)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	found, err := q.Find(func(task Task) bool { return task.FuncPath() == "f" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 3 {
+		t.Fatalf("found %d tasks", len(found))
+	}
+
+	for range tasks {
+		task, err := q.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Execute(task)
+		q.Release()
+	}
+	if len(got) != 3 || got[0] != 1 || got[1] != 1000 || got[2] != 1000 {
+		t.Errorf("got %v", got)
+	}
+}
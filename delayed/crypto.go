@@ -0,0 +1,285 @@
+package delayed
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/shamaton/msgpack/v2"
+)
+
+// signedExtType is the MessagePack extension type of the signed tasks.
+const signedExtType = 2
+
+var (
+	UnknownKeyError        = errors.New("Unknown key")
+	UnsignedTaskError      = errors.New("Unsigned task")
+	InvalidSignatureError  = errors.New("Invalid signature")
+	EncryptedPayloadError  = errors.New("Encrypted payload")
+	InvalidCiphertextError = errors.New("Invalid ciphertext")
+)
+
+// Keyring stores the keys identified by their IDs.
+// The current key is used to encrypt or sign new tasks, and all the keys can be used to decrypt or verify tasks,
+// so the keys can be rotated without failing the queued tasks.
+type Keyring struct {
+	id   string
+	keys map[string][]byte
+}
+
+// NewKeyring creates a Keyring with the current key, its ID shouldn't be empty.
+// The key should be 16, 24 or 32 bytes for AES-128, AES-192 or AES-256 if it's used to encrypt tasks.
+func NewKeyring(id string, key []byte) *Keyring {
+	return &Keyring{
+		id:   id,
+		keys: map[string][]byte{id: key},
+	}
+}
+
+// AddKey adds an old key, which is only used to decrypt or verify the tasks enqueued before rotating it.
+// It returns the keyring itself.
+func (k *Keyring) AddKey(id string, key []byte) *Keyring {
+	if id != k.id {
+		k.keys[id] = key
+	}
+	return k
+}
+
+// key returns the key of the ID.
+func (k *Keyring) key(id string) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, UnknownKeyError
+	}
+	return key, nil
+}
+
+// QueueEncryption encrypts the payloads of the GoTasks enqueued to the queue by AES-GCM with the current key of the keyring.
+// The workers should be created by queues with keyrings containing the key, they decrypt the payloads before executing the tasks.
+// The payloads of the follow-up tasks of a chain or a chord are encrypted with the task carrying them,
+// and those of a DAG workflow are encrypted when they are added to the workflow created by the queue.
+// The other fields (eg: the function path and the headers) are not encrypted, neither are the results kept for chords and DAG workflows.
+// The payloads of the PyTasks are not encrypted, and the Python version can't decrypt the GoTasks.
+func QueueEncryption(keys *Keyring) QueueOption {
+	return func(q *Queue) {
+		q.encryptionKeys = keys
+	}
+}
+
+// QueueSigning signs the tasks enqueued to the queue by HMAC-SHA256 with the current key of the keyring.
+// The worker verifies the signature of a task before deserializing it, and drops the tasks without valid signatures (they are not kept by KeepFailed()),
+// so only the producers knowing the keys can enqueue tasks, even if others can access Redis.
+// Upgrades all the workers before enabling it, the workers of older versions and the Python version can't deserialize the signed tasks.
+func QueueSigning(keys *Keyring) QueueOption {
+	return func(q *Queue) {
+		q.signingKeys = keys
+	}
+}
+
+// encrypt encrypts the payload of a GoTask and its follow-up tasks if the queue has an encryption keyring.
+func (q *Queue) encrypt(t *GoTask) error {
+	if q.encryptionKeys == nil {
+		return nil
+	}
+	if w := t.raw.Workflow; w != nil && (len(w.Next) > 0 || len(w.Callback) > 0) {
+		if err := q.encryptFollowUps(w); err != nil {
+			return err
+		}
+		t.data = nil // should be serialized again
+	}
+	if t.raw.Encryption != "" || t.raw.Blob != "" {
+		return nil
+	}
+
+	_, err := t.Serialize()
+	if err != nil {
+		return err
+	}
+	if !hasArg(t.raw.Payload) { // keeps it empty, so a follow-up task can receive the result as its arg
+		return nil
+	}
+
+	id := q.encryptionKeys.id
+	payload, err := seal(q.encryptionKeys.keys[id], t.raw.Payload)
+	if err != nil {
+		return err
+	}
+	t.setEncryptedPayload(id, payload)
+	return nil
+}
+
+// encryptFollowUps encrypts the serialized follow-up tasks of a chain or a chord.
+// The encrypted tasks are not changed when they are enqueued by the worker, unless they receive the results as their args.
+func (q *Queue) encryptFollowUps(w *RawWorkflow) (err error) {
+	for i, data := range w.Next {
+		w.Next[i], err = q.encryptSerialized(data)
+		if err != nil {
+			return
+		}
+	}
+	if len(w.Callback) > 0 {
+		w.Callback, err = q.encryptSerialized(w.Callback)
+	}
+	return
+}
+
+// encryptSerialized encrypts a serialized GoTask and its follow-up tasks.
+func (q *Queue) encryptSerialized(data []byte) ([]byte, error) {
+	t, err := DeserializeGoTask(data)
+	if err != nil {
+		return nil, err
+	}
+	err = q.encrypt(t)
+	if err != nil {
+		return nil, err
+	}
+	return t.Serialize()
+}
+
+// decrypt decrypts the payload of a GoTask fetched from the blob store (if offloaded) by the encryption keyring of the queue.
+func (q *Queue) decrypt(t *GoTask) error {
+	if t.raw.Encryption == "" || t.plaintext != nil {
+		return nil
+	}
+	if q.encryptionKeys == nil {
+		return UnknownKeyError
+	}
+
+	key, err := q.encryptionKeys.key(t.raw.Encryption)
+	if err != nil {
+		return err
+	}
+	t.plaintext, err = open(key, t.raw.Payload)
+	return err
+}
+
+// seal encrypts the data by AES-GCM, the random nonce is prepended to the ciphertext.
+func seal(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(data)+gcm.Overhead())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+// open decrypts the data encrypted by seal().
+func open(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, InvalidCiphertextError
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// rawSignedTask store the fields of a signed task, which is serialized as a MessagePack extension.
+type rawSignedTask struct {
+	KeyID     string // the ID of the signing key
+	Signature []byte // the HMAC-SHA256 of Data
+	Data      []byte // the serialized (and maybe compressed) task
+}
+
+// sign signs the serialized task if the queue has a signing keyring, a signed task is not signed again.
+// It's only called when enqueuing a task, the dequeued tasks are requeued with their original signatures (or without signatures),
+// otherwise a forged task would be signed by the worker.
+func (q *Queue) sign(data []byte) ([]byte, error) {
+	if q.signingKeys == nil {
+		return data, nil
+	}
+	if extType, body, err := unmarshalExt(data); err != nil || body != nil && extType == signedExtType {
+		return data, err
+	}
+
+	id := q.signingKeys.id
+	return marshalExt(signedExtType, &rawSignedTask{
+		KeyID:     id,
+		Signature: signature(q.signingKeys.keys[id], data),
+		Data:      data,
+	})
+}
+
+// verify verifies the signature of a serialized task if the queue has a signing keyring.
+func (q *Queue) verify(data []byte) error {
+	if q == nil || q.signingKeys == nil { // q may be nil if a Worker was not created by NewWorker()
+		return nil
+	}
+
+	extType, body, err := unmarshalExt(data)
+	if err != nil {
+		return err
+	}
+	if body == nil || extType != signedExtType {
+		return UnsignedTaskError
+	}
+
+	var raw rawSignedTask
+	err = msgpack.UnmarshalAsArray(body, &raw)
+	if err != nil {
+		return err
+	}
+	key, err := q.signingKeys.key(raw.KeyID)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(raw.Signature, signature(key, raw.Data)) {
+		return InvalidSignatureError
+	}
+	return nil
+}
+
+// rejectUnverified releases a dequeued task without a valid signature.
+// It's not kept as a failed task, since its data may be anything written by others who can access Redis.
+// The task is not deserialized, so the event of it has no task ID or function path.
+func (q *Queue) rejectUnverified(conn redis.Conn, data []byte, err error) {
+	q.logger.Error("Rejected unverified task.", "queue", q.name, "worker_id", q.workerID, "size", len(data), "error", err)
+	_, e := conn.Do("HDEL", q.processingKey, q.workerID)
+	if e != nil {
+		q.logger.Error("Failed to release task.", "queue", q.name, "worker_id", q.workerID, "error", e)
+	}
+	q.emit(&Event{
+		Type:     EventTaskFailed,
+		WorkerID: q.workerID,
+		Error:    err.Error(),
+	})
+}
+
+// unsign strips the signature of a signed task without verifying it.
+func unsign(data []byte) ([]byte, error) {
+	extType, body, err := unmarshalExt(data)
+	if err != nil || body == nil || extType != signedExtType {
+		return data, err
+	}
+
+	var raw rawSignedTask
+	err = msgpack.UnmarshalAsArray(body, &raw)
+	if err != nil {
+		return nil, err
+	}
+	return raw.Data, nil
+}
+
+func signature(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
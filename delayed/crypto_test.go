@@ -0,0 +1,343 @@
+package delayed
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testEncryptionKey = []byte("0123456789abcdef0123456789abcdef")
+	testSigningKey    = []byte("secret")
+)
+
+func TestEncryption(t *testing.T) {
+	oldKeys := NewKeyring("k1", testEncryptionKey)
+	keys := NewKeyring("k2", []byte("fedcba9876543210")).AddKey("k1", testEncryptionKey)
+	producer := NewQueue("test", NewRedisPool(redisAddr), QueueEncryption(oldKeys))
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2), QueueEncryption(keys))
+	defer q.Clear()
+
+	var got []string
+	w := NewWorker(q)
+	w.RegisterHandlerAs("f", func(s string) {
+		got = append(got, s)
+	})
+
+	secret := "top secret"
+	tasks := []*GoTask{
+		NewGoTask("f", secret),
+		NewGoTask("f", strings.Repeat(secret, 10)),
+	}
+	err := producer.Enqueue(tasks[0]) // encrypted by the old key
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = q.Enqueue(tasks[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tasks[0].raw.Encryption != "k1" || tasks[1].raw.Encryption != "k2" {
+		t.Errorf("got keys %q and %q", tasks[0].raw.Encryption, tasks[1].raw.Encryption)
+	}
+	for _, task := range tasks {
+		data, err := task.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte(secret)) {
+			t.Errorf("the payload is not encrypted: %x", data)
+		}
+		var arg string
+		if err = task.DecodeArg(&arg); err != EncryptedPayloadError {
+			t.Errorf("got error %v", err)
+		}
+	}
+
+	incompatible, err := w.CheckQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(incompatible) != 0 {
+		t.Errorf("got incompatible task %#v", incompatible[0])
+	}
+
+	for range tasks {
+		task, err := q.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Execute(task)
+		q.Release()
+	}
+	if len(got) != 2 || got[0] != secret || got[1] != strings.Repeat(secret, 10) {
+		t.Errorf("got %v", got)
+	}
+
+	r := &eventRecorder{}
+	w = NewWorker(NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2), OnEvent(r.handle))) // without the keys
+	w.RegisterHandlerAs("f", func(s string) {
+		t.Errorf("got %q", s)
+	})
+	err = q.Enqueue(NewGoTask("f", secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := w.queue.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Execute(task)
+	w.queue.Release()
+	assertEventTypes(t, r.types(), EventTaskDequeued, EventTaskFailed)
+	if r.events[1].Error != UnknownKeyError.Error() {
+		t.Errorf("got error %s", r.events[1].Error)
+	}
+}
+
+func TestEncryptionFollowUp(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2), QueueEncryption(NewKeyring("k1", testEncryptionKey)))
+	defer q.Clear()
+
+	var got []int
+	w := NewWorker(q)
+	w.RegisterHandlerAs("f", func(a int) int {
+		got = append(got, a)
+		return a + 1
+	})
+
+	task, err := Chain(NewGoTask("f", 1), NewGoTask("f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = q.Enqueue(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		task, err := q.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.raw.Encryption != "k1" {
+			t.Errorf("task %d is not encrypted", i)
+		}
+		w.Execute(task)
+		q.Release()
+	}
+	if len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("got %v", got)
+	}
+
+	secret := "top secret"
+	var secrets []string
+	w.RegisterHandlerAs("s", func(s string) {
+		secrets = append(secrets, s)
+	})
+	w.RegisterHandlerAs("g", func(results []int) {
+		got = append(got, results...)
+	})
+
+	chain, err := Chain(NewGoTask("f", 1), NewGoTask("s", secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chord, err := Chord([]*GoTask{NewGoTask("f", 3)}, NewGoTask("g", secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wf := NewWorkflow(q)
+	conn := q.redis.Get()
+	defer conn.Close()
+	defer conn.Do("DEL", wf.key)
+	err = wf.Add("a", NewGoTask("s", secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(wf.nodes[0].Task, []byte(secret)) {
+		t.Errorf("the payload of the workflow task is not encrypted: %x", wf.nodes[0].Task)
+	}
+
+	for _, task := range []*GoTask{chain, chord[0]} {
+		err = q.Enqueue(task)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := task.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte(secret)) {
+			t.Errorf("the payload of the follow-up task is not encrypted: %x", data)
+		}
+	}
+	err = wf.Submit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := drain(t, q, w); n != 5 {
+		t.Errorf("executed %d tasks", n)
+	}
+	if len(secrets) != 2 || secrets[0] != secret || secrets[1] != secret {
+		t.Errorf("got %v", secrets)
+	}
+	if len(got) != 5 || got[4] != 4 {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestSigning(t *testing.T) {
+	r := &eventRecorder{}
+	keys := NewKeyring("k2", []byte("new secret")).AddKey("k1", testSigningKey)
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2), QueueSigning(keys), QueueCompression(GzipCompressor, 100), KeepFailed(10), OnEvent(r.handle))
+	defer q.Clear()
+
+	called := 0
+	w := NewWorker(q)
+	w.RegisterHandlerAs("f", func(s string) {
+		called++
+	})
+
+	forged := NewGoTask("f", strings.Repeat("test", 100))
+	forged.SetCompression(GzipCompressor, 100)
+	data, err := forged.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = (&Queue{signingKeys: NewKeyring("k1", []byte("guessed secret"))}).sign(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged.data = data
+
+	unsigned := NewQueue("test", NewRedisPool(redisAddr))
+	tasks := []struct {
+		task     Task
+		producer *Queue
+	}{
+		{NewGoTask("f", "test"), q},
+		{NewGoTask("f", strings.Repeat("test", 100)), q}, // compressed and signed
+		{NewPyTask("f", []interface{}{"test"}, nil), NewQueue("test", NewRedisPool(redisAddr), QueueSigning(NewKeyring("k1", testSigningKey)))}, // signed by the old key
+		{NewGoTask("f", "test"), unsigned},
+		{forged, unsigned}, // signed by a wrong key
+	}
+	for _, tt := range tasks {
+		err = tt.producer.Enqueue(tt.task)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	found, err := q.Find(func(task Task) bool { return task.FuncPath() == "f" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != len(tasks) {
+		t.Fatalf("found %d tasks", len(found))
+	}
+
+	r.events = nil
+	for range tasks {
+		task, err := q.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task != nil {
+			w.Execute(task)
+			q.Release()
+		}
+	}
+	if called != 3 {
+		t.Errorf("called %d times", called)
+	}
+	assertEventTypes(t, r.types(),
+		EventTaskDequeued, EventTaskSucceeded,
+		EventTaskDequeued, EventTaskSucceeded,
+		EventTaskDequeued, EventTaskSucceeded,
+		EventTaskFailed, // rejected before being deserialized
+		EventTaskFailed,
+	)
+	if r.events[6].Error != UnsignedTaskError.Error() || r.events[7].Error != InvalidSignatureError.Error() {
+		t.Errorf("got errors %s and %s", r.events[6].Error, r.events[7].Error)
+	}
+	processing, err := q.ProcessingLen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if processing != 0 {
+		t.Errorf("got %d processing tasks", processing)
+	}
+
+	conn := q.redis.Get()
+	defer conn.Close()
+	_, err = conn.Do("RPUSH", q.name, []byte{1, 2, 3}) // garbage
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Do("RPUSH", q.notiKey, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := q.Dequeue()
+	if err != nil || task != nil {
+		t.Fatalf("got task %v and error %v", task, err)
+	}
+	failed, err := q.Failed(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 0 { // the unverified tasks are not kept
+		t.Fatalf("got %d failed tasks", len(failed))
+	}
+
+	defer func(size int) { MaxDecompressedSize = size }(MaxDecompressedSize)
+	MaxDecompressedSize = 100
+	err = unsigned.Enqueue(forged) // decompressed to more than MaxDecompressedSize bytes
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.events = nil
+	task, err = q.Dequeue()
+	if err != nil || task != nil {
+		t.Fatalf("got task %v and error %v", task, err)
+	}
+	assertEventTypes(t, r.types(), EventTaskFailed)
+	if r.events[0].Error != InvalidSignatureError.Error() {
+		t.Errorf("got error %s", r.events[0].Error)
+	}
+}
+
+func TestCryptoVectors(t *testing.T) {
+	q := &Queue{
+		encryptionKeys: NewKeyring("k1", testEncryptionKey),
+		signingKeys:    NewKeyring("k1", testSigningKey),
+	}
+
+	data, _ := hex.DecodeString("c74a0293a26b31c4200715e5c36a7c502ca53828507a8ae76f289005f6b2c83ea586b4d9e95328a369c42298a76d61696e2e6633c40101b030313233343536373839616263646566c0c0a0a0a0") // go_signed
+	err := q.verify(data)
+	if err != nil {
+		t.Errorf("got error %v", err)
+	}
+	data[len(data)-1] = 0xc0
+	err = q.verify(data)
+	if err != InvalidSignatureError {
+		t.Errorf("got error %v", err)
+	}
+
+	data, _ = hex.DecodeString("98a76d61696e2e6633c41d851603f66dca1edb2efe947d2e22f6dd8d27197f331143e472c3381efbb030313233343536373839616263646566c0c0a0a0a26b31") // go_encrypted
+	task, err := DeserializeGoTask(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = q.decrypt(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(task.plaintext, []byte{1}) {
+		t.Errorf("got payload %x", task.plaintext)
+	}
+}
@@ -0,0 +1,61 @@
+package delayed
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// Definition is the interface of TaskDefinition, which can be registered by Worker.Register().
+type Definition interface {
+	FuncPath() string
+	handler() *Handler
+}
+
+// TaskDefinition binds a function path to a handler with typed args,
+// so the args of its tasks are checked at compile time on both the producer and the consumer sides.
+// Its tasks have the same format as those created by NewGoTask(funcPath, args).
+type TaskDefinition[Args any] struct {
+	funcPath string
+	fn       func(context.Context, Args) error
+}
+
+// Define creates a task definition of the function path and the handler.
+// The handler receives the context of the task, which is canceled when the task is canceled by Queue.Cancel().
+func Define[Args any](funcPath string, fn func(ctx context.Context, args Args) error) *TaskDefinition[Args] {
+	return &TaskDefinition[Args]{
+		funcPath: funcPath,
+		fn:       fn,
+	}
+}
+
+// FuncPath returns the function path of the definition.
+func (d *TaskDefinition[Args]) FuncPath() string {
+	return d.funcPath
+}
+
+// NewTask creates a new GoTask with the args.
+func (d *TaskDefinition[Args]) NewTask(args Args) *GoTask {
+	return NewGoTask(d.funcPath, args)
+}
+
+// Enqueue creates a new GoTask with the args and enqueues it to the queue.
+func (d *TaskDefinition[Args]) Enqueue(ctx context.Context, q *Queue, args Args) error {
+	return q.EnqueueContext(ctx, d.NewTask(args))
+}
+
+func (d *TaskDefinition[Args]) handler() *Handler {
+	return newHandler(reflect.ValueOf(d.fn), d.funcPath)
+}
+
+// Register registers the handlers of the task definitions.
+func (w *Worker) Register(defs ...Definition) {
+	for _, d := range defs {
+		h := d.handler()
+		if h != nil {
+			w.handlers[h.path] = h
+		} else {
+			w.getLogger().Warn("Invalid handler.", "queue", w.queueName(), "worker_id", w.id, "handler", fmt.Sprintf("%#v", d))
+		}
+	}
+}
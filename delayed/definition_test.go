@@ -0,0 +1,58 @@
+package delayed
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDefinition(t *testing.T) {
+	var got testArg
+	def := Define("test.define", func(ctx context.Context, arg testArg) error {
+		got = arg
+		return nil
+	})
+	if def.FuncPath() != "test.define" {
+		t.Fatalf("got func path %s", def.FuncPath())
+	}
+
+	// keeps the same wire format
+	arg := testArg{A: 1, B: "test"}
+	task := def.NewTask(arg)
+	data, err := task.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	task2 := NewGoTask("test.define", arg)
+	task2.raw.ID = task.raw.ID
+	data2, err := task2.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, data2) {
+		t.Fatalf("got data %x, want %x", data, data2)
+	}
+
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2))
+	defer q.Clear()
+	w := NewWorker(q)
+	w.Register(def, Define[int]("test.nil", nil))
+	if len(w.handlers) != 1 {
+		t.Fatalf("registered %d handlers", len(w.handlers))
+	}
+
+	err = def.Enqueue(context.Background(), q, arg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err = q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Execute(task)
+	q.Release()
+	if got != arg {
+		t.Errorf("got arg %#v", got)
+	}
+}
@@ -0,0 +1,102 @@
+package delayed
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const eventsKeySuffix = "_events"
+
+// EventType is the type of an event.
+type EventType string
+
+const (
+	EventWorkerStarted EventType = "worker_started"
+	EventWorkerStopped EventType = "worker_stopped"
+	EventWorkerDied    EventType = "worker_died" // found by RequeueLost(), the worker stopped without releasing its task
+	EventTaskEnqueued  EventType = "task_enqueued"
+	EventTaskDequeued  EventType = "task_dequeued"
+	EventTaskSucceeded EventType = "task_succeeded"
+	EventTaskFailed    EventType = "task_failed"
+	EventTaskCanceled  EventType = "task_canceled"  // a running task was canceled by Queue.Cancel(), or a revoked task was skipped
+	EventTaskRequeued  EventType = "task_requeued"  // a lost task was requeued by RequeueLost()
+	EventTaskDeferred  EventType = "task_deferred"  // a dequeued task was moved to the end of the queue, eg: it exceeded the rate limit
+	EventDequeueFailed EventType = "dequeue_failed" // the worker will sleep for a while before dequeuing again
+)
+
+// Event describes something happened to a worker or a task of a queue.
+type Event struct {
+	Type     EventType     `json:"type"`
+	Queue    string        `json:"queue"`
+	WorkerID string        `json:"worker_id,omitempty"`
+	TaskID   string        `json:"task_id,omitempty"`
+	FuncPath string        `json:"func_path,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"` // the execution time of a finished task
+	Time     time.Time     `json:"time"`
+}
+
+// EventHandler handles an event.
+// It's called synchronously by the queue or the worker, so it should return quickly.
+type EventHandler func(*Event)
+
+// OnEvent adds event handlers to a queue.
+// The handlers receive events of the queue and its worker.
+func OnEvent(handlers ...EventHandler) QueueOption {
+	return func(q *Queue) {
+		q.eventHandlers = append(q.eventHandlers, handlers...)
+	}
+}
+
+// PublishEvents publishes events of a queue to the Redis channel named "{queue name}_events" in JSON format.
+func PublishEvents() QueueOption {
+	return func(q *Queue) {
+		q.publishEvents = true
+	}
+}
+
+func (q *Queue) hasEventListeners() bool {
+	return q != nil && (len(q.eventHandlers) > 0 || q.publishEvents) // q may be nil if a Worker was not created by NewWorker()
+}
+
+func (q *Queue) emit(e *Event) {
+	if !q.hasEventListeners() {
+		return
+	}
+
+	e.Queue = q.name
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	for _, h := range q.eventHandlers {
+		h(e)
+	}
+
+	if q.publishEvents {
+		data, err := json.Marshal(e)
+		if err != nil {
+			q.logger.Error("Failed to marshal event.", "queue", q.name, "event", e.Type, "error", err)
+			return
+		}
+
+		conn := q.redis.Get()
+		defer conn.Close()
+
+		_, err = conn.Do("PUBLISH", q.eventsKey, data)
+		if err != nil {
+			q.logger.Error("Failed to publish event.", "queue", q.name, "event", e.Type, "error", err)
+		}
+	}
+}
+
+func (q *Queue) emitTaskEvent(typ EventType, task Task) {
+	if q.hasEventListeners() {
+		q.emit(&Event{
+			Type:     typ,
+			WorkerID: q.workerID,
+			TaskID:   task.ID(),
+			FuncPath: task.FuncPath(),
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package delayed
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+type eventRecorder struct {
+	events []*Event
+}
+
+func (r *eventRecorder) handle(e *Event) {
+	r.events = append(r.events, e)
+}
+
+func (r *eventRecorder) types() []EventType {
+	types := make([]EventType, len(r.events))
+	for i, e := range r.events {
+		types[i] = e.Type
+	}
+	return types
+}
+
+func assertEventTypes(t *testing.T, got []EventType, want ...EventType) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got events %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got events %v, want %v", got, want)
+		}
+	}
+}
+
+func errorFunc(s string) error {
+	if s == "" {
+		return nil
+	}
+	return errors.New(s)
+}
+
+func TestQueueEvents(t *testing.T) {
+	r := &eventRecorder{}
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2), OnEvent(r.handle))
+	defer q.Clear()
+
+	task := NewGoTask("test", tArg)
+	err := q.Enqueue(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = q.RequeueLost()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEventTypes(t, r.types(), EventTaskEnqueued, EventTaskDequeued, EventWorkerDied, EventTaskRequeued)
+	for _, e := range r.events {
+		if e.Queue != "test" {
+			t.Errorf("event %s has queue %s", e.Type, e.Queue)
+		}
+		if e.Type != EventWorkerDied && (e.TaskID != task.raw.ID || e.FuncPath != "test") {
+			t.Errorf("event %s has task %s %s", e.Type, e.TaskID, e.FuncPath)
+		}
+	}
+}
+
+func TestWorkerEvents(t *testing.T) {
+	var w *Worker
+	r := &eventRecorder{}
+	stop := func(e *Event) {
+		if e.Type == EventTaskFailed && e.FuncPath == "github.com/yizhisec/go-delayed/delayed.panicFunc" {
+			w.Stop()
+		}
+	}
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2), OnEvent(r.handle, stop))
+	defer q.Clear()
+
+	w = NewWorker(q)
+	w.RegisterHandlers(errorFunc, panicFunc)
+
+	q.Enqueue(NewGoTaskOfFunc(errorFunc, ""))
+	q.Enqueue(NewGoTaskOfFunc(errorFunc, "error"))
+	q.Enqueue(NewGoTaskOfFunc(panicFunc, "panic"))
+	r.events = nil
+	w.Run()
+
+	assertEventTypes(t, r.types(),
+		EventWorkerStarted,
+		EventTaskDequeued, EventTaskSucceeded,
+		EventTaskDequeued, EventTaskFailed,
+		EventTaskDequeued, EventTaskFailed,
+		EventWorkerStopped,
+	)
+	if r.events[4].Error != "error" || r.events[6].Error != "panic: panic" {
+		t.Errorf("got errors %q and %q", r.events[4].Error, r.events[6].Error)
+	}
+
+	conn := q.redis.Get()
+	defer conn.Close()
+	count, err := redis.Int(conn.Do("HLEN", q.processingKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("%d tasks are not released", count)
+	}
+}
+
+func TestPublishEvents(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr), PublishEvents())
+	defer q.Clear()
+
+	conn := q.redis.Get()
+	defer conn.Close()
+	psc := redis.PubSubConn{Conn: conn}
+	err := psc.Subscribe(q.eventsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer psc.Unsubscribe()
+
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Subscription:
+			err = q.Enqueue(NewGoTask("test"))
+			if err != nil {
+				t.Fatal(err)
+			}
+		case redis.Message:
+			var e Event
+			err = json.Unmarshal(v.Data, &e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.Type != EventTaskEnqueued || e.Queue != "test" || e.FuncPath != "test" {
+				t.Errorf("got event %s", v.Data)
+			}
+			return
+		case error:
+			t.Fatal(v)
+		}
+	}
+}
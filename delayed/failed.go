@@ -0,0 +1,182 @@
+package delayed
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/shamaton/msgpack/v2"
+)
+
+const (
+	failedKeySuffix = "_failed"
+
+	// KEYS: failed_key, queue_name, noti_key
+	// ARGV: failed_record, task
+	requeueFailedScript = `local removed = redis.call('lrem', KEYS[1], 1, ARGV[1])
+if removed > 0 then
+    redis.call('rpush', KEYS[2], ARGV[2])
+    redis.call('rpush', KEYS[3], '1')
+end
+return removed`
+)
+
+// rawFailedTask stores the fields need to be serialized for a FailedTask.
+type rawFailedTask struct {
+	Data     []byte // serialized task
+	Error    string
+	WorkerID string
+	FailedAt int64 // Unix time in milliseconds
+}
+
+// FailedTask is a task failed to execute, it's kept if KeepFailed() is set.
+type FailedTask struct {
+	Task     *GoTask // nil if the kept record can't be deserialized, see DecodeError
+	Error    string
+	WorkerID string
+	FailedAt time.Time
+
+	DecodeError error // the error of deserializing the kept record, eg: it's written by an incompatible version
+
+	record []byte // the serialized rawFailedTask, used to remove it from Redis
+}
+
+// KeepFailed keeps at most n recently failed tasks of a queue, so they can be inspected and requeued.
+// It's disabled by default.
+func KeepFailed(n int) QueueOption {
+	return func(q *Queue) {
+		if n > 0 {
+			q.keepFailed = n
+		} else {
+			q.keepFailed = 0
+		}
+	}
+}
+
+func (q *Queue) addFailed(task *GoTask, taskErr error) (err error) {
+	data, err := task.Serialize()
+	if err != nil {
+		return
+	}
+	record, err := msgpack.MarshalAsArray(&rawFailedTask{
+		Data:     data,
+		Error:    taskErr.Error(),
+		WorkerID: q.workerID,
+		FailedAt: time.Now().UnixNano() / int64(time.Millisecond),
+	})
+	if err != nil {
+		return
+	}
+
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	err = conn.Send("LPUSH", q.failedKey, record)
+	if err != nil {
+		return
+	}
+	_, err = conn.Do("LTRIM", q.failedKey, 0, q.keepFailed-1)
+	return
+}
+
+// Failed returns at most limit recently failed tasks from the offset, the latest one comes first.
+// A record which can't be deserialized is returned with a nil Task and its DecodeError, so it won't hide the others.
+func (q *Queue) Failed(offset, limit int) (tasks []*FailedTask, err error) {
+	if limit <= 0 {
+		return
+	}
+
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	records, err := redis.ByteSlices(conn.Do("LRANGE", q.failedKey, offset, offset+limit-1))
+	if err != nil {
+		return
+	}
+
+	tasks = make([]*FailedTask, len(records))
+	for i, record := range records {
+		tasks[i] = deserializeFailedTask(record)
+	}
+	return
+}
+
+// deserializeFailedTask deserializes a kept record, the error is stored in its DecodeError.
+func deserializeFailedTask(record []byte) *FailedTask {
+	task := &FailedTask{record: record}
+	var raw rawFailedTask
+	task.DecodeError = msgpack.UnmarshalAsArray(record, &raw)
+	if task.DecodeError != nil {
+		return task
+	}
+
+	task.Error = raw.Error
+	task.WorkerID = raw.WorkerID
+	task.FailedAt = time.Unix(0, raw.FailedAt*int64(time.Millisecond))
+	task.Task, task.DecodeError = DeserializeGoTask(raw.Data)
+	return task
+}
+
+func (q *Queue) findFailed(taskID string) (task *FailedTask, err error) {
+	tasks, err := q.Failed(0, q.keepFailedLen())
+	if err != nil {
+		return
+	}
+	for _, t := range tasks {
+		if t.Task != nil && t.Task.raw.ID == taskID {
+			return t, nil
+		}
+	}
+	return
+}
+
+// keepFailedLen returns the max length of the failed list.
+// The queue may be created without KeepFailed() for inspecting, so it's not limited in this case.
+func (q *Queue) keepFailedLen() int {
+	if q.keepFailed > 0 {
+		return q.keepFailed
+	}
+	return int(^uint(0) >> 2)
+}
+
+// RequeueFailed moves a failed task back to the end of the queue.
+// It returns false if the task is not found. A task without ID (eg: enqueued by an old version) can't be requeued.
+func (q *Queue) RequeueFailed(taskID string) (ok bool, err error) {
+	if taskID == "" {
+		return
+	}
+	task, err := q.findFailed(taskID)
+	if err != nil || task == nil {
+		return
+	}
+
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	removed, err := redis.Int(q.requeueFailedScript.Do(conn, q.failedKey, q.name, q.notiKey, task.record, task.Task.data))
+	if err != nil {
+		return
+	}
+	if removed > 0 {
+		q.logger.Debug("Requeued failed task.", "queue", q.name, "task_id", taskID, "func_path", task.Task.raw.FuncPath)
+		q.emitTaskEvent(EventTaskEnqueued, task.Task)
+	}
+	return removed > 0, nil
+}
+
+// RemoveFailed removes a failed task.
+// It returns false if the task is not found. A task without ID can't be removed, it's dropped when the list is full.
+func (q *Queue) RemoveFailed(taskID string) (ok bool, err error) {
+	if taskID == "" {
+		return
+	}
+	task, err := q.findFailed(taskID)
+	if err != nil || task == nil {
+		return
+	}
+
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	removed, err := redis.Int(conn.Do("LREM", q.failedKey, 1, task.record))
+	return removed > 0, err
+}
@@ -0,0 +1,133 @@
+package delayed
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shamaton/msgpack/v2"
+)
+
+func TestKeepFailed(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2), KeepFailed(2))
+	defer q.Clear()
+	w := NewWorker(q)
+	w.RegisterHandlers(errorFunc)
+
+	for _, s := range []string{"error1", "", "error2", "error3"} {
+		task := NewGoTaskOfFunc(errorFunc, s)
+		task.Serialize()
+		w.Execute(task)
+	}
+
+	failed, err := q.Failed(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 2 {
+		t.Fatalf("got %d failed tasks", len(failed))
+	}
+	if failed[0].Error != "error3" || failed[1].Error != "error2" {
+		t.Errorf("got errors %s, %s", failed[0].Error, failed[1].Error)
+	}
+	if failed[0].WorkerID != w.id || time.Since(failed[0].FailedAt) > time.Minute {
+		t.Errorf("got %+v", failed[0])
+	}
+
+	stats, err := q.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Failed != 2 {
+		t.Errorf("got %d failed tasks", stats.Failed)
+	}
+
+	ok, err := q.RequeueFailed(failed[0].Task.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("failed to requeue the task")
+	}
+	ok, err = q.RequeueFailed(failed[0].Task.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("requeued the task twice")
+	}
+
+	task, err := q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task == nil || task.ID() != failed[0].Task.ID() {
+		t.Fatalf("got %#v", task)
+	}
+
+	ok, err = q.RemoveFailed(failed[1].Task.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("failed to remove the task")
+	}
+	failed, err = q.Failed(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 0 {
+		t.Errorf("got %d failed tasks", len(failed))
+	}
+}
+
+func TestFailedUndecodable(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2), KeepFailed(10))
+	defer q.Clear()
+	w := NewWorker(q)
+	w.RegisterHandlers(errorFunc)
+
+	task := NewGoTaskOfFunc(errorFunc, "error")
+	task.Serialize()
+	w.Execute(task)
+
+	conn := q.redis.Get()
+	defer conn.Close()
+	_, err := conn.Do("LPUSH", q.failedKey, []byte{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed, err := q.Failed(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 2 {
+		t.Fatalf("got %d failed tasks", len(failed))
+	}
+	if failed[0].Task != nil || failed[0].DecodeError == nil {
+		t.Errorf("got %+v", failed[0])
+	}
+	if failed[1].Task == nil || failed[1].Task.ID() != task.ID() || failed[1].DecodeError != nil {
+		t.Errorf("got %+v", failed[1])
+	}
+
+	noID, err := msgpack.MarshalAsArray(&rawFailedTask{Data: []byte{0x92, 0xa6, 'm', 'a', 'i', 'n', '.', 'f', 0xc0}}) // ["main.f", nil]
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Do("LPUSH", q.failedKey, noID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []func(string) (bool, error){q.RequeueFailed, q.RemoveFailed} {
+		ok, err := f("")
+		if err != nil || ok {
+			t.Errorf("got %v and error %v", ok, err)
+		}
+	}
+
+	ok, err := q.RequeueFailed(task.ID())
+	if err != nil || !ok {
+		t.Fatalf("failed to requeue: %v", err)
+	}
+}
@@ -1,34 +1,48 @@
 package delayed
 
 import (
+	"context"
 	"reflect"
 	"runtime"
 	"strconv"
 	"strings"
+)
 
-	"github.com/keakon/golog/log"
-	"github.com/shamaton/msgpack/v2"
+var (
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
 )
 
 // A handler stores a function and other information about how to call it.
 type Handler struct {
 	fn         reflect.Value // the reflected function
 	path       string
-	argCount   int
+	argCount   int             // the count of the arguments except the context
 	arg        interface{}     // a point to the only argument or to a struct which represents the arguments
 	args       []reflect.Value // the prebuilt arguments for fn.Call() or fn.CallSlice(), each element of it references the same one as arg (the only argument) or one field of arg (a struct represents the arguments)
 	isVariadic bool
+	hasContext bool // the first argument is a context.Context, it's not serialized in the payload
+	hasStruct  bool // some arguments are (or contain) structs, whose fields are decoded by position by MsgpackArrayCodec
+
+	validatable bool            // some arguments (or the elements of the variadic argument) may implement Validator
+	validators  []reflect.Value // the functions validate the arguments, see Worker.RegisterValidator()
 }
 
 // NewHandler creates a handler for a function.
-func NewHandler(f interface{}) (h *Handler) {
+// If the first argument of the function is a context.Context, it receives the context of the task,
+// which is canceled when the task is canceled by Queue.Cancel().
+func NewHandler(f interface{}) *Handler {
 	fn := reflect.ValueOf(f)
 	if fn.Kind() != reflect.Func {
 		return nil
 	}
 
-	path := runtime.FuncForPC(fn.Pointer()).Name()
-	if path == "" {
+	return newHandler(fn, runtime.FuncForPC(fn.Pointer()).Name())
+}
+
+// newHandler creates a handler for a reflected function with the path, it returns nil if the function is nil or the path is empty.
+func newHandler(fn reflect.Value, path string) (h *Handler) {
+	if path == "" || fn.IsNil() {
 		return nil
 	}
 
@@ -39,20 +53,28 @@ func NewHandler(f interface{}) (h *Handler) {
 		argCount: fnType.NumIn(),
 	}
 
+	first := 0 // the index of the first serialized argument
+	if h.argCount > 0 && fnType.In(0) == contextType {
+		h.hasContext = true
+		h.argCount--
+		first = 1
+	}
+
 	// the rest fields can be reused among tasks, because the worker won't handle tasks concurrently
 	if h.argCount == 0 {
 		h.args = []reflect.Value{}
 	} else {
 		h.isVariadic = strings.Contains(fnType.String(), "...")
 		if h.argCount == 1 {
-			argType := fnType.In(0)
+			argType := fnType.In(first)
 			arg := reflect.New(argType)
 			h.arg = arg.Interface()
 			h.args = []reflect.Value{arg.Elem()}
+			h.hasStruct = hasStruct(argType, map[reflect.Type]bool{})
 		} else {
 			fields := make([]reflect.StructField, h.argCount)
 			for i := 0; i < h.argCount; i++ {
-				arg := fnType.In(i)
+				arg := fnType.In(first + i)
 				fields[i] = reflect.StructField{
 					Name: "F" + strconv.Itoa(i),
 					Type: arg,
@@ -66,17 +88,48 @@ func NewHandler(f interface{}) (h *Handler) {
 			for i := 0; i < h.argCount; i++ {
 				h.args[i] = argElem.Field(i)
 			}
+			h.hasStruct = true // the args are decoded as a struct
 		}
 	}
+	for i := first; i < fnType.NumIn(); i++ {
+		argType := fnType.In(i)
+		if h.isVariadic && i == fnType.NumIn()-1 {
+			argType = argType.Elem()
+		}
+		if validatable(argType) {
+			h.validatable = true
+		}
+	}
+	if h.hasContext {
+		h.args = append([]reflect.Value{reflect.ValueOf(context.Background())}, h.args...)
+	}
 	return
 }
 
 // Call executes the function of a handler.
 func (h *Handler) Call(payload []byte) (result []reflect.Value, err error) {
-	if h.argCount > 0 && len(payload) > 0 {
-		err := msgpack.UnmarshalAsArray(payload, h.arg)
+	return h.CallContext(context.Background(), payload)
+}
+
+// CallContext executes the function of a handler, the context is passed to the function if it accepts one.
+func (h *Handler) CallContext(ctx context.Context, payload []byte) (result []reflect.Value, err error) {
+	return h.call(ctx, MsgpackArrayCodec, payload)
+}
+
+// call executes the function of a handler with the payload serialized by the codec.
+func (h *Handler) call(ctx context.Context, codec Codec, payload []byte) (result []reflect.Value, err error) {
+	if h.hasContext {
+		h.args[0] = reflect.ValueOf(&ctx).Elem()
+	}
+	if h.argCount > 0 {
+		arg := reflect.ValueOf(h.arg).Elem()
+		arg.Set(reflect.Zero(arg.Type())) // clears the args of the previous call
+		err = h.decode(codec, payload, h.arg)
+		if err != nil {
+			return nil, err
+		}
+		err = h.validate()
 		if err != nil {
-			log.Errorf("Failed to unmarshal payload: %v", err)
 			return nil, err
 		}
 	}
@@ -85,3 +138,16 @@ func (h *Handler) Call(payload []byte) (result []reflect.Value, err error) {
 	}
 	return h.fn.Call(h.args), nil
 }
+
+// returnedError returns the last result of a function call if it's a non-nil error.
+func returnedError(result []reflect.Value) error {
+	if len(result) == 0 {
+		return nil
+	}
+
+	last := result[len(result)-1]
+	if last.Kind() != reflect.Interface || !last.Type().Implements(errorType) || last.IsNil() {
+		return nil
+	}
+	return last.Interface().(error)
+}
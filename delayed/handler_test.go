@@ -1,6 +1,7 @@
 package delayed
 
 import (
+	"context"
 	"reflect"
 	"testing"
 
@@ -402,3 +403,35 @@ func TestHandlerCall(t *testing.T) {
 		})
 	}
 }
+
+func ctxValue(ctx context.Context, n int) int {
+	v, _ := ctx.Value(ctxKey{}).(int)
+	return v + n
+}
+
+func TestHandlerCallContext(t *testing.T) {
+	h := NewHandler(ctxValue)
+	if h.argCount != 1 || !h.hasContext {
+		t.Fatalf("got argCount %d, hasContext %v", h.argCount, h.hasContext)
+	}
+
+	payload, err := msgpack.MarshalAsArray(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := h.CallContext(context.WithValue(context.Background(), ctxKey{}, 1), payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result[0].Int() != 3 {
+		t.Errorf("got %d", result[0].Int())
+	}
+
+	result, err = h.Call(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result[0].Int() != 2 {
+		t.Errorf("got %d", result[0].Int())
+	}
+}
@@ -0,0 +1,49 @@
+package delayed
+
+import "context"
+
+// EnqueueInterceptor intercepts enqueuing a task.
+// It must call next to continue enqueuing, and may modify the task (eg: set headers) before it.
+type EnqueueInterceptor func(ctx context.Context, q *Queue, task Task, next func(context.Context) error) error
+
+// ExecuteInterceptor intercepts executing a task by a worker.
+// It must call next to continue executing, which returns the error of the handler.
+type ExecuteInterceptor func(ctx context.Context, w *Worker, task *GoTask, next func(context.Context) error) error
+
+// InterceptEnqueue adds enqueue interceptors to a queue.
+// The first interceptor is the outermost one.
+func InterceptEnqueue(interceptors ...EnqueueInterceptor) QueueOption {
+	return func(q *Queue) {
+		q.enqueueInterceptors = append(q.enqueueInterceptors, interceptors...)
+	}
+}
+
+// InterceptExecute adds execute interceptors to a worker.
+// The first interceptor is the outermost one.
+func InterceptExecute(interceptors ...ExecuteInterceptor) WorkerOption {
+	return func(w *Worker) {
+		w.executeInterceptors = append(w.executeInterceptors, interceptors...)
+	}
+}
+
+func (q *Queue) interceptEnqueue(ctx context.Context, task Task, enqueue func(context.Context) error) error {
+	next := enqueue
+	for i := len(q.enqueueInterceptors) - 1; i >= 0; i-- {
+		interceptor, n := q.enqueueInterceptors[i], next
+		next = func(ctx context.Context) error {
+			return interceptor(ctx, q, task, n)
+		}
+	}
+	return next(ctx)
+}
+
+func (w *Worker) interceptExecute(ctx context.Context, task *GoTask, execute func(context.Context) error) error {
+	next := execute
+	for i := len(w.executeInterceptors) - 1; i >= 0; i-- {
+		interceptor, n := w.executeInterceptors[i], next
+		next = func(ctx context.Context) error {
+			return interceptor(ctx, w, task, n)
+		}
+	}
+	return next(ctx)
+}
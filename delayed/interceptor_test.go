@@ -0,0 +1,93 @@
+package delayed
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type ctxKey struct{}
+
+func TestInterceptEnqueue(t *testing.T) {
+	var calls []string
+	interceptor := func(name string) EnqueueInterceptor {
+		return func(ctx context.Context, q *Queue, task Task, next func(context.Context) error) error {
+			calls = append(calls, name)
+			if t, ok := task.(*GoTask); ok {
+				t.SetHeader(name, ctx.Value(ctxKey{}).(string))
+			}
+			return next(ctx)
+		}
+	}
+
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2), InterceptEnqueue(interceptor("a"), interceptor("b")))
+	defer q.Clear()
+
+	task := NewGoTask("test", 1)
+	_, err := task.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = q.EnqueueContext(context.WithValue(context.Background(), ctxKey{}, "value"), task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 2 || calls[0] != "a" || calls[1] != "b" {
+		t.Fatalf("got calls %v", calls)
+	}
+
+	task2, err := q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task2 == nil || task2.ID() != task.ID() || task2.Headers()["a"] != "value" || task2.Headers()["b"] != "value" {
+		t.Fatalf("got task %#v", task2)
+	}
+
+	abort := errors.New("abort")
+	q = NewQueue("test", NewRedisPool(redisAddr), InterceptEnqueue(func(ctx context.Context, q *Queue, task Task, next func(context.Context) error) error {
+		return abort
+	}))
+	err = q.Enqueue(NewGoTask("test"))
+	if err != abort {
+		t.Fatalf("got error %v", err)
+	}
+	count, err := q.Len()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.FailNow()
+	}
+}
+
+func TestInterceptExecute(t *testing.T) {
+	var calls []string
+	var gotErr error
+	interceptor := func(name string) ExecuteInterceptor {
+		return func(ctx context.Context, w *Worker, task *GoTask, next func(context.Context) error) error {
+			calls = append(calls, name)
+			err := next(ctx)
+			if name == "a" {
+				gotErr = err
+			}
+			return err
+		}
+	}
+
+	w := NewWorker(NewQueue("test", NewRedisPool(redisAddr)), InterceptExecute(interceptor("a"), interceptor("b")))
+	w.RegisterHandlers(errorFunc)
+	task := NewGoTaskOfFunc(errorFunc, "error")
+	_, err := task.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Execute(task)
+	if len(calls) != 2 || calls[0] != "a" || calls[1] != "b" {
+		t.Fatalf("got calls %v", calls)
+	}
+	if gotErr == nil || gotErr.Error() != "error" {
+		t.Fatalf("got error %v", gotErr)
+	}
+}
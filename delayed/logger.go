@@ -0,0 +1,92 @@
+package delayed
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/keakon/golog"
+	"github.com/keakon/golog/log"
+)
+
+// Logger is the interface of a structured logger used by queues, workers and sweepers.
+// The args are alternating keys and values, eg: logger.Debug("Dequeued task.", "queue", "default", "task_id", id)
+// It's satisfied by *slog.Logger.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// DefaultLogger is used by queues, workers and sweepers without a logger set.
+// It writes to the default logger of golog, whose args are formatted as "key=value".
+var DefaultLogger Logger = gologLogger{}
+
+type gologLogger struct{}
+
+func (gologLogger) Debug(msg string, args ...interface{}) {
+	if log.IsEnabledFor(golog.DebugLevel) { // avoid formatting the args
+		log.Debug(formatLog(msg, args))
+	}
+}
+
+func (gologLogger) Info(msg string, args ...interface{}) {
+	if log.IsEnabledFor(golog.InfoLevel) {
+		log.Info(formatLog(msg, args))
+	}
+}
+
+func (gologLogger) Warn(msg string, args ...interface{}) {
+	if log.IsEnabledFor(golog.WarnLevel) {
+		log.Warn(formatLog(msg, args))
+	}
+}
+
+func (gologLogger) Error(msg string, args ...interface{}) {
+	if log.IsEnabledFor(golog.ErrorLevel) {
+		log.Error(formatLog(msg, args))
+	}
+}
+
+func formatLog(msg string, args []interface{}) string {
+	if len(args) == 0 {
+		return msg
+	}
+
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v", args[i])
+		}
+	}
+	return b.String()
+}
+
+// QueueLogger sets the logger of a queue.
+// It's also used by the worker of the queue unless WorkerLogger() is set.
+func QueueLogger(l Logger) QueueOption {
+	return func(q *Queue) {
+		if l != nil {
+			q.logger = l
+		}
+	}
+}
+
+// WorkerLogger sets the logger of a worker.
+func WorkerLogger(l Logger) WorkerOption {
+	return func(w *Worker) {
+		if l != nil {
+			w.logger = l
+		}
+	}
+}
+
+// SetLogger sets the logger of the sweeper.
+func (s *Sweeper) SetLogger(l Logger) {
+	if l != nil {
+		s.logger = l
+	}
+}
@@ -0,0 +1,16 @@
+//go:build go1.21
+// +build go1.21
+
+package delayed
+
+import "log/slog"
+
+var _ Logger = (*slog.Logger)(nil)
+
+// SlogLogger adapts a *slog.Logger to Logger, slog.Default() is used if l is nil.
+func SlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		return slog.Default()
+	}
+	return l
+}
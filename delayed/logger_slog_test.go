@@ -0,0 +1,27 @@
+//go:build go1.21
+// +build go1.21
+
+package delayed
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := SlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	q := NewQueue("test", NewRedisPool(redisAddr), QueueLogger(l))
+	defer q.Clear()
+
+	task := NewGoTask("test")
+	err := q.Enqueue(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `msg="Enqueued task." queue=test task_id=`+task.ID()+` func_path=test`) {
+		t.Errorf("got log %s", buf.String())
+	}
+}
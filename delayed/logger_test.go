@@ -0,0 +1,93 @@
+package delayed
+
+import (
+	"testing"
+	"time"
+)
+
+type logRecord struct {
+	level string
+	msg   string
+	args  []interface{}
+}
+
+type memoryLogger struct {
+	records []logRecord
+}
+
+func (l *memoryLogger) log(level, msg string, args []interface{}) {
+	l.records = append(l.records, logRecord{level: level, msg: msg, args: args})
+}
+
+func (l *memoryLogger) Debug(msg string, args ...interface{}) { l.log("debug", msg, args) }
+func (l *memoryLogger) Info(msg string, args ...interface{})  { l.log("info", msg, args) }
+func (l *memoryLogger) Warn(msg string, args ...interface{})  { l.log("warn", msg, args) }
+func (l *memoryLogger) Error(msg string, args ...interface{}) { l.log("error", msg, args) }
+
+func (l *memoryLogger) find(msg string) map[interface{}]interface{} {
+	for _, r := range l.records {
+		if r.msg == msg {
+			fields := map[interface{}]interface{}{}
+			for i := 0; i+1 < len(r.args); i += 2 {
+				fields[r.args[i]] = r.args[i+1]
+			}
+			return fields
+		}
+	}
+	return nil
+}
+
+func TestFormatLog(t *testing.T) {
+	tests := []struct {
+		msg  string
+		args []interface{}
+		want string
+	}{
+		{msg: "test", args: nil, want: "test"},
+		{msg: "test", args: []interface{}{"a", 1}, want: "test a=1"},
+		{msg: "test", args: []interface{}{"a", 1, "b", "c"}, want: "test a=1 b=c"},
+		{msg: "test", args: []interface{}{"a", 1, "b"}, want: "test a=1 b"},
+	}
+	for _, tt := range tests {
+		if got := formatLog(tt.msg, tt.args); got != tt.want {
+			t.Errorf("formatLog() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestLogger(t *testing.T) {
+	queueLogger := &memoryLogger{}
+	workerLogger := &memoryLogger{}
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2), QueueLogger(queueLogger))
+	defer q.Clear()
+
+	w := NewWorker(q, WorkerLogger(workerLogger))
+	w.RegisterHandlers(errorFunc)
+
+	task := NewGoTaskOfFunc(errorFunc, "error")
+	err := q.Enqueue(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task2, err := q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Execute(task2)
+
+	fields := queueLogger.find("Dequeued task.")
+	if fields == nil || fields["queue"] != "test" || fields["worker_id"] != w.id || fields["task_id"] != task.ID() || fields["func_path"] != task.FuncPath() {
+		t.Errorf("got fields %v", fields)
+	}
+
+	fields = workerLogger.find("Failed to execute task.")
+	if fields == nil || fields["queue"] != "test" || fields["task_id"] != task.ID() || fields["error"].(error).Error() != "error" {
+		t.Errorf("got fields %v", fields)
+	}
+	if _, ok := fields["duration"].(time.Duration); !ok {
+		t.Errorf("got duration %v", fields["duration"])
+	}
+	if queueLogger.find("Failed to execute task.") != nil {
+		t.Error("the worker logger is not used")
+	}
+}
@@ -0,0 +1,38 @@
+package delayed
+
+import "github.com/gomodule/redigo/redis"
+
+const pausedKeySuffix = "_paused"
+
+// Pause stops the workers of the queue from dequeuing tasks until Resume() is called.
+// The running tasks are not affected, and tasks can still be enqueued.
+func (q *Queue) Pause() error {
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("SET", q.pausedKey, 1)
+	if err == nil {
+		q.logger.Info("Paused queue.", "queue", q.name)
+	}
+	return err
+}
+
+// Resume lets the workers of the queue continue dequeuing tasks.
+func (q *Queue) Resume() error {
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("DEL", q.pausedKey)
+	if err == nil {
+		q.logger.Info("Resumed queue.", "queue", q.name)
+	}
+	return err
+}
+
+// IsPaused returns whether the queue is paused.
+func (q *Queue) IsPaused() (paused bool, err error) {
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	return redis.Bool(conn.Do("EXISTS", q.pausedKey))
+}
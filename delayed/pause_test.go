@@ -0,0 +1,62 @@
+package delayed
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueuePause(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2))
+	defer q.Clear()
+	NewWorker(q)
+
+	task := NewGoTask("test", 1)
+	q.Enqueue(task)
+
+	err := q.Pause()
+	if err != nil {
+		t.Fatal(err)
+	}
+	paused, err := q.IsPaused()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !paused {
+		t.Fatal("the queue is not paused")
+	}
+
+	dequeued, err := q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dequeued != nil {
+		t.Fatalf("dequeued %#v from a paused queue", dequeued)
+	}
+	stats, err := q.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := QueueStats{Name: "test", Len: 1, NotiLen: 1, Paused: true}
+	if *stats != want {
+		t.Errorf("got %+v, want %+v", *stats, want)
+	}
+
+	err = q.Resume()
+	if err != nil {
+		t.Fatal(err)
+	}
+	paused, err = q.IsPaused()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paused {
+		t.Fatal("the queue is still paused")
+	}
+	dequeued, err = q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dequeued == nil || dequeued.ID() != task.ID() {
+		t.Fatalf("dequeued %#v", dequeued)
+	}
+}
@@ -0,0 +1,81 @@
+package delayed
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// protocolVectors are the golden test vectors described in PROTOCOL.md, all the implementations should decode them identically.
+type protocolVectors struct {
+	Version int `json:"version"`
+	Vectors []struct {
+		Name string          `json:"name"`
+		Type string          `json:"type"` // "go" or "py"
+		Data string          `json:"data"` // hex encoded task
+		Task json.RawMessage `json:"task"` // the decoded task
+	} `json:"vectors"`
+}
+
+func TestProtocolVectors(t *testing.T) {
+	f, err := ioutil.ReadFile("testdata/protocol/v1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors protocolVectors
+	err = json.Unmarshal(f, &vectors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vectors.Version != ProtocolVersion {
+		t.Fatalf("got version %d", vectors.Version)
+	}
+
+	for _, v := range vectors.Vectors {
+		t.Run(v.Name, func(t *testing.T) {
+			data, err := hex.DecodeString(v.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			task, err := DeserializeTask(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			switch task := task.(type) {
+			case *GoTask:
+				if v.Type != "go" {
+					t.Fatalf("decoded as GoTask")
+				}
+				if data[0] == 0x90|byte(reflect.TypeOf(RawGoTask{}).NumField()) { // encoded by the current version
+					task.data = nil
+					encoded, err := task.Serialize()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(encoded, data) {
+						t.Errorf("encoded as %x", encoded)
+					}
+				}
+			case *PyTask:
+				if v.Type != "py" {
+					t.Fatalf("decoded as PyTask")
+				}
+			}
+
+			got, err := json.Marshal(task)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gotValue, wantValue interface{}
+			json.Unmarshal(got, &gotValue)
+			json.Unmarshal(v.Task, &wantValue)
+			if !reflect.DeepEqual(gotValue, wantValue) {
+				t.Errorf("decoded as %s, want %s", got, v.Task)
+			}
+		})
+	}
+}
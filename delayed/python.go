@@ -0,0 +1,234 @@
+package delayed
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/shamaton/msgpack/v2"
+)
+
+var InvalidPyArgsError = errors.New("Invalid args of PyTask")
+
+// deserializeDequeuedTask deserializes a dequeued task, a PyTask is wrapped as a GoTask,
+// so it can be executed by the handler registered with its function path (eg: "app.tasks:resize").
+func deserializeDequeuedTask(data []byte) (task *GoTask, err error) {
+	raw, c, err := decompress(data)
+	if err != nil {
+		return
+	}
+	if !isPyTaskData(raw) {
+		return deserializeGoTask(data, raw, c)
+	}
+
+	pyTask, err := deserializePyTask(data, raw, c)
+	if err != nil {
+		return
+	}
+	return pyTask.goTask(), nil
+}
+
+// isPyTaskData checks the MessagePack type of the fields of a serialized task without decoding it.
+// A GoTask is [FuncPath, Payload (bin or nil), ID (string), Headers...], and a PyTask is [FuncPath, Args (array or nil), KwArgs (map or nil)].
+// It's used by both the workers and DeserializeTask(), so a task is never treated as a GoTask by one and a PyTask by the other.
+func isPyTaskData(data []byte) bool {
+	n, i := arrayLen(data)
+	if n < 3 { // a PyTask always has 3 fields
+		return false
+	}
+
+	if i >= len(data) { // skips FuncPath
+		return false
+	}
+	code := data[i]
+	switch {
+	case code >= 0xa0 && code <= 0xbf: // fixstr
+		i += 1 + int(code&0x1f)
+	case code == 0xd9 && i+1 < len(data): // str8
+		i += 2 + int(data[i+1])
+	case code == 0xda && i+2 < len(data): // str16
+		i += 3 + int(binary.BigEndian.Uint16(data[i+1:]))
+	case code == 0xdb && i+4 < len(data): // str32
+		i += 5 + int(binary.BigEndian.Uint32(data[i+1:]))
+	default:
+		return false
+	}
+
+	if i >= len(data) {
+		return false
+	}
+	code = data[i]
+	switch {
+	case code >= 0xc4 && code <= 0xc6: // bin
+		return false
+	case code == 0xc0: // nil, checks the next field
+		if i+1 >= len(data) {
+			return false
+		}
+		code = data[i+1]
+		return !(code >= 0xa0 && code <= 0xbf || code >= 0xd9 && code <= 0xdb) // not a string ID
+	}
+	return true
+}
+
+// arrayLen returns the length of a MessagePack array and the size of its header, or -1 if data is not an array.
+func arrayLen(data []byte) (n, size int) {
+	if len(data) == 0 {
+		return -1, 0
+	}
+	code := data[0]
+	switch {
+	case code >= 0x90 && code <= 0x9f:
+		return int(code & 0x0f), 1
+	case code == 0xdc && len(data) >= 3:
+		return int(binary.BigEndian.Uint16(data[1:])), 3
+	case code == 0xdd && len(data) >= 5:
+		return int(binary.BigEndian.Uint32(data[1:])), 5
+	}
+	return -1, 0
+}
+
+// goTask wraps the PyTask as a GoTask, its args are bound to the handler when it's executed.
+func (t *PyTask) goTask() *GoTask {
+	return &GoTask{
+		raw: RawGoTask{
+			FuncPath: t.raw.FuncPath,
+		},
+		data: t.data,
+		py:   &t.raw,
+	}
+}
+
+// pyPayload converts the args and kwargs of a PyTask into the payload of the handler.
+// If the handler has only one struct (or pointer to struct) arg, the args are bound to its fields in order,
+// and the kwargs (or the only dict arg) are bound to its fields by their msgpack tag names (or field names).
+// Otherwise the args are bound to the args of the handler in order, and the kwargs are not allowed.
+func (h *Handler) pyPayload(raw *RawPyTask) ([]byte, error) {
+	args, err := pyArgs(raw.Args)
+	if err != nil {
+		return nil, err
+	}
+	kwArgs, err := pyKwArgs(raw.KwArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.argCount == 0 {
+		if len(args) > 0 || len(kwArgs) > 0 {
+			return nil, fmt.Errorf("%s takes no arguments", h.path)
+		}
+		return nil, nil
+	}
+
+	if h.argCount == 1 && !h.isVariadic {
+		argType := reflect.TypeOf(h.arg).Elem()
+		if argType.Kind() == reflect.Ptr {
+			argType = argType.Elem()
+		}
+		if argType.Kind() == reflect.Struct {
+			if len(args) == 1 && len(kwArgs) == 0 { // a dict is passed as the only arg
+				if m, err := pyKwArgs(args[0]); err == nil && m != nil {
+					args, kwArgs = nil, m
+				}
+			}
+			return bindFields(argType, args, kwArgs)
+		}
+	}
+
+	if len(kwArgs) > 0 {
+		return nil, fmt.Errorf("%s takes no keyword arguments", h.path)
+	}
+
+	if h.isVariadic && len(args) >= h.argCount-1 { // packs the rest args into the last one
+		rest := args[h.argCount-1:]
+		args = append(args[:h.argCount-1:h.argCount-1], rest)
+	}
+	if len(args) > h.argCount {
+		return nil, fmt.Errorf("%s takes %d arguments but %d were given", h.path, h.argCount, len(args))
+	}
+	if h.argCount == 1 {
+		if len(args) == 0 {
+			return nil, nil
+		}
+		return msgpack.MarshalAsArray(args[0])
+	}
+	for len(args) < h.argCount {
+		args = append(args, nil)
+	}
+	return msgpack.MarshalAsArray(args)
+}
+
+// bindFields binds the args and kwargs to the fields of a struct, and returns its serialized data.
+func bindFields(structType reflect.Type, args []interface{}, kwArgs map[string]interface{}) ([]byte, error) {
+	var names []string // the names of the serialized fields, in the same way as msgpack
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" { // unexported
+			continue
+		}
+		name := field.Tag.Get("msgpack")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names = append(names, name)
+	}
+
+	if len(args) > len(names) {
+		return nil, fmt.Errorf("%s takes %d arguments but %d were given", structType, len(names), len(args))
+	}
+	values := make([]interface{}, len(names))
+	copy(values, args)
+	for key, value := range kwArgs {
+		found := false
+		for i, name := range names {
+			if name == key {
+				if i < len(args) {
+					return nil, fmt.Errorf("%s got multiple values for argument %q", structType, key)
+				}
+				values[i] = value
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s got an unexpected keyword argument %q", structType, key)
+		}
+	}
+	return msgpack.MarshalAsArray(values)
+}
+
+func pyArgs(args interface{}) ([]interface{}, error) {
+	switch args := args.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		return args, nil
+	default:
+		return nil, InvalidPyArgsError
+	}
+}
+
+func pyKwArgs(kwArgs interface{}) (map[string]interface{}, error) {
+	switch kwArgs := kwArgs.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		return kwArgs, nil
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(kwArgs))
+		for k, v := range kwArgs {
+			key, ok := k.(string)
+			if !ok {
+				return nil, InvalidPyArgsError
+			}
+			m[key] = v
+		}
+		return m, nil
+	default:
+		return nil, InvalidPyArgsError
+	}
+}
@@ -0,0 +1,146 @@
+package delayed
+
+import (
+	"testing"
+	"time"
+)
+
+type resizeArgs struct {
+	URL    string `msgpack:"url"`
+	Width  int    `msgpack:"width"`
+	Height int    `msgpack:"height"`
+	secret int
+}
+
+func TestIsPyTaskData(t *testing.T) {
+	tests := []struct {
+		task Task
+		want bool
+	}{
+		{NewGoTask("test.f", 1), false},
+		{NewGoTask("test.f"), false},
+		{NewGoTask("test.f", []int{1, 2}), false},
+		{NewPyTask("app.tasks:f", []int{1, 2}, nil), true},
+		{NewPyTask("app.tasks:f", []int{1, 2}, map[string]int{"a": 1}), true},
+		{NewPyTask("app.tasks:f", nil, map[string]int{"a": 1}), true},
+		{NewPyTask("app.tasks:f", nil, nil), true},
+		{NewPyTask(string(make([]byte, 40)), []int{1}, nil), true},
+	}
+	for _, tt := range tests {
+		data, err := tt.task.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := isPyTaskData(data); got != tt.want {
+			t.Errorf("isPyTaskData(%x) = %v, want %v", data, got, tt.want)
+		}
+		task, err := DeserializeTask(data) // must agree with the workers
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := task.(*PyTask); ok != tt.want {
+			t.Errorf("DeserializeTask(%x) returned %T", data, task)
+		}
+	}
+	if isPyTaskData(nil) || isPyTaskData([]byte{0x93}) || isPyTaskData([]byte{0x93, 0xa3, 'a'}) {
+		t.Error("invalid data is treated as PyTask")
+	}
+}
+
+func TestHandlerPyPayload(t *testing.T) {
+	tests := []struct {
+		fn     interface{}
+		args   interface{}
+		kwArgs interface{}
+		want   int
+		err    bool
+	}{
+		{f5, nil, nil, 0, false},
+		{f5, []interface{}{1}, nil, 0, true},
+		{f3, []interface{}{1}, nil, 1, false},
+		{f3, nil, map[interface{}]interface{}{"a": 1}, 0, true},
+		{f6, []interface{}{1, 2}, nil, 3, false},
+		{f6, []interface{}{1}, nil, 1, false},
+		{f6, []interface{}{1, 2, 3}, nil, 0, true},
+		{f12, []interface{}{1, 2, 3}, nil, 6, false},
+		{f13, []interface{}{[]interface{}{1}, 2, 3}, nil, 6, false},
+		{f1, []interface{}{1, "ab"}, nil, 3, false},
+		{f1, []interface{}{1}, map[interface{}]interface{}{"B": "ab"}, 3, false},
+		{f2, []interface{}{map[interface{}]interface{}{"A": 1, "B": "ab"}}, nil, 3, false},
+		{f1, []interface{}{1}, map[interface{}]interface{}{"A": 2}, 0, true},
+		{f1, nil, map[interface{}]interface{}{"C": 2}, 0, true},
+		{f1, []interface{}{1, "ab", 3}, nil, 0, true},
+		{f1, 1, nil, 0, true},
+		{f1, nil, 1, 0, true},
+	}
+	for i, tt := range tests {
+		h := NewHandler(tt.fn)
+		payload, err := h.pyPayload(&RawPyTask{Args: tt.args, KwArgs: tt.kwArgs})
+		if err != nil {
+			if !tt.err {
+				t.Errorf("test %d got error: %v", i, err)
+			}
+			continue
+		}
+		if tt.err {
+			t.Errorf("test %d expected an error", i)
+			continue
+		}
+		result, err := h.Call(payload)
+		if err != nil {
+			t.Errorf("test %d got error: %v", i, err)
+			continue
+		}
+		if got := int(result[0].Int()); got != tt.want {
+			t.Errorf("test %d got %d, want %d", i, got, tt.want)
+		}
+	}
+}
+
+func TestWorkerExecutePyTask(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2))
+	defer q.Clear()
+	w := NewWorker(q)
+	var got resizeArgs
+	w.RegisterHandlerAs("app.tasks:resize", func(args resizeArgs) {
+		got = args
+	})
+
+	err := q.Enqueue(NewPyTask("app.tasks:resize", []string{"a.png"}, map[string]int{"width": 200}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task == nil || task.FuncPath() != "app.tasks:resize" {
+		t.Fatalf("got task %#v", task)
+	}
+	w.Execute(task)
+	q.Release()
+	if got != (resizeArgs{URL: "a.png", Width: 200}) {
+		t.Errorf("got args %#v", got)
+	}
+
+	called := false
+	w.RegisterHandlerAs("app.tasks:ping", func() {
+		called = true
+	})
+	err = q.Enqueue(NewPyTask("app.tasks:ping", nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err = q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task == nil || task.py == nil {
+		t.Fatalf("got task %#v", task)
+	}
+	w.Execute(task)
+	q.Release()
+	if !called {
+		t.Error("the handler is not called")
+	}
+}
@@ -1,36 +1,44 @@
 package delayed
 
 import (
+	"context"
 	"errors"
+	"reflect"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
-	"github.com/keakon/golog"
-	"github.com/keakon/golog/log"
 )
 
 const (
 	notiKeySuffix       = "_noti"
 	processingKeySuffix = "_processing"
+	workersKeySuffix    = "_workers"
 
 	defaultDequeueTimeout   float32 = 1
 	defaultKeepAliveTimeout float32 = 60
 )
 
 const (
-	// KEYS: queue_name, processing_key
+	// KEYS: queue_name, processing_key, revoked_key, paused_key, noti_key
 	// ARGV: worker_id
-	dequeueScript = `local task = redis.call('lpop', KEYS[1])
-if task == nil then
+	// returns: task, revoked_count; or 0 if the queue is paused
+	dequeueScript = `if redis.call('exists', KEYS[4]) == 1 then
+    redis.call('lpush', KEYS[5], '1')
+    return 0
+end
+local task = redis.call('lpop', KEYS[1])
+if not task then
     return nil
 end
 redis.call('hset', KEYS[2], ARGV[1], task)
-return task`
+return {task, redis.call('zcard', KEYS[3])}`
 
 	// KEYS: queue_name, noti_key, processing_key
+	// returns: count, worker_id1, task1, worker_id2, task2...
 	requeueLostScript = `local queue_len = redis.call('llen', KEYS[1])
 local noti_len = redis.call('llen', KEYS[2])
 local count = queue_len - noti_len
+local result = {0}
 local processing_tasks = redis.call('hgetall', KEYS[3])
 for i = 1, #processing_tasks, 2 do
     local worker_id = processing_tasks[i]
@@ -39,6 +47,8 @@ for i = 1, #processing_tasks, 2 do
         count = count + 1
         redis.call('rpush', KEYS[1], processing_tasks[i + 1])
         redis.call('hdel', KEYS[3], worker_id)
+        table.insert(result, worker_id)
+        table.insert(result, processing_tasks[i + 1])
     end
 end
 if count > 0 then
@@ -48,7 +58,24 @@ if count > 0 then
     end
     redis.call('lpush', KEYS[2], unpack(noti_array))
 end
-return count`
+result[1] = count
+return result`
+
+	// KEYS: queue_name, noti_key, processing_key
+	// ARGV: worker_id, task
+	requeueScript = `redis.call('hdel', KEYS[3], ARGV[1])
+redis.call('rpush', KEYS[1], ARGV[2])
+redis.call('rpush', KEYS[2], '1')`
+
+	// KEYS: queue_name, noti_key
+	// ARGV: task
+	removeScript = `local removed = redis.call('lrem', KEYS[1], 1, ARGV[1])
+if removed > 0 then
+    redis.call('lpop', KEYS[2])
+end
+return removed`
+
+	scanBatchSize = 100
 )
 
 var InvalidRedisReplyError = errors.New("Invalid redis reply")
@@ -59,6 +86,11 @@ type Queue struct {
 	name             string
 	notiKey          string
 	processingKey    string
+	workersKey       string
+	eventsKey        string
+	failedKey        string
+	revokedKey       string
+	pausedKey        string
 	dequeueTimeout   float32 // seconds
 	keepAliveTimeout float32 // seconds
 
@@ -67,7 +99,31 @@ type Queue struct {
 	requeueScript     *redis.Script
 	requeueLostScript *redis.Script
 
-	handlers map[string]*Handler
+	removeScript        *redis.Script
+	requeueFailedScript *redis.Script
+	keepFailed          int // the max count of the kept failed tasks
+
+	registry         *Registry                // checks the args of the enqueued tasks, see CheckArgs()
+	validators       map[string]reflect.Value // validates the args of the enqueued GoTasks, see Queue.RegisterValidator()
+	allowedFuncPaths map[string]bool          // nil for allowing all the function paths
+
+	eventHandlers []EventHandler
+	publishEvents bool
+
+	enqueueInterceptors []EnqueueInterceptor
+
+	codec Codec // the default codec of the enqueued GoTasks
+
+	compressor           Compressor // compresses the enqueued tasks larger than compressionThreshold
+	compressionThreshold int
+
+	blobStore     BlobStore // stores the payloads of the enqueued GoTasks larger than blobThreshold
+	blobThreshold int
+
+	encryptionKeys *Keyring // encrypts the payloads of the enqueued GoTasks
+	signingKeys    *Keyring // signs the enqueued tasks and verifies the dequeued tasks
+
+	logger Logger
 }
 
 type QueueOption func(*Queue)
@@ -98,14 +154,23 @@ func KeepAliveTimeout(d time.Duration) QueueOption {
 // NewQueue creates a new queue.
 func NewQueue(name string, redisPool *redis.Pool, options ...QueueOption) *Queue {
 	queue := &Queue{
-		name:              name,
-		notiKey:           name + notiKeySuffix,
-		processingKey:     name + processingKeySuffix,
-		dequeueTimeout:    defaultDequeueTimeout,
-		keepAliveTimeout:  defaultKeepAliveTimeout,
-		redis:             redisPool,
-		dequeueScript:     redis.NewScript(2, dequeueScript),
-		requeueLostScript: redis.NewScript(3, requeueLostScript),
+		name:                name,
+		notiKey:             name + notiKeySuffix,
+		processingKey:       name + processingKeySuffix,
+		workersKey:          name + workersKeySuffix,
+		eventsKey:           name + eventsKeySuffix,
+		failedKey:           name + failedKeySuffix,
+		revokedKey:          name + revokedKeySuffix,
+		pausedKey:           name + pausedKeySuffix,
+		dequeueTimeout:      defaultDequeueTimeout,
+		keepAliveTimeout:    defaultKeepAliveTimeout,
+		redis:               redisPool,
+		dequeueScript:       redis.NewScript(5, dequeueScript),
+		requeueScript:       redis.NewScript(3, requeueScript),
+		requeueLostScript:   redis.NewScript(3, requeueLostScript),
+		removeScript:        redis.NewScript(2, removeScript),
+		requeueFailedScript: redis.NewScript(3, requeueFailedScript),
+		logger:              DefaultLogger,
 	}
 
 	for _, option := range options {
@@ -119,9 +184,14 @@ func (q *Queue) keepAlive() error {
 	conn := q.redis.Get()
 	defer conn.Close()
 
-	_, err := conn.Do("SETEX", q.workerID, q.keepAliveTimeout, 1)
+	err := conn.Send("SETEX", q.workerID, q.keepAliveTimeout, 1)
+	if err != nil {
+		return err
+	}
+	expireTime := float64(time.Now().UnixNano())/float64(time.Second) + float64(q.keepAliveTimeout)
+	_, err = conn.Do("ZADD", q.workersKey, expireTime, q.workerID)
 	if err == nil {
-		log.Debugf("Worker %s is alive.", q.workerID)
+		q.logger.Debug("Worker is alive.", "queue", q.name, "worker_id", q.workerID)
 	}
 	return err
 }
@@ -130,7 +200,11 @@ func (q *Queue) die() error {
 	conn := q.redis.Get()
 	defer conn.Close()
 
-	_, err := conn.Do("DEL", q.workerID)
+	err := conn.Send("DEL", q.workerID)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Do("ZREM", q.workersKey, q.workerID)
 	return err
 }
 
@@ -139,10 +213,28 @@ func (q *Queue) Clear() error {
 	conn := q.redis.Get()
 	defer conn.Close()
 
-	_, err := conn.Do("DEL", q.name, q.notiKey, q.processingKey, q.workerID)
+	_, err := conn.Do("DEL", q.name, q.notiKey, q.processingKey, q.workersKey, q.failedKey, q.revokedKey, q.pausedKey, q.workerID)
 	return err
 }
 
+// Purge removes all the pending and processing tasks of the queue.
+// Unlike Clear(), it keeps the paused state, the failed tasks, the revoked marks and the live workers.
+func (q *Queue) Purge() error {
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("DEL", q.name, q.notiKey, q.processingKey)
+	if err == nil {
+		q.logger.Info("Purged queue.", "queue", q.name)
+	}
+	return err
+}
+
+// Name returns the name of the queue.
+func (q *Queue) Name() string {
+	return q.name
+}
+
 // Len returns the task count of the queue.
 func (q *Queue) Len() (count int, err error) {
 	conn := q.redis.Get()
@@ -151,14 +243,271 @@ func (q *Queue) Len() (count int, err error) {
 	return redis.Int(conn.Do("LLEN", q.name))
 }
 
+// ProcessingLen returns the count of the tasks being processed by workers.
+func (q *Queue) ProcessingLen() (count int, err error) {
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	return redis.Int(conn.Do("HLEN", q.processingKey))
+}
+
+// QueueStats is the statistics of a queue.
+type QueueStats struct {
+	Name       string `json:"name"`
+	Len        int    `json:"len"`        // the length of the task list
+	NotiLen    int    `json:"noti_len"`   // the length of the notification list, should be equal to Len unless some tasks are lost
+	Processing int    `json:"processing"` // the count of the tasks being processed by workers
+	Workers    int    `json:"workers"`    // the count of the live workers
+	Failed     int    `json:"failed"`     // the count of the kept failed tasks
+	Paused     bool   `json:"paused"`
+}
+
+// Stats returns the statistics of the queue.
+func (q *Queue) Stats() (stats *QueueStats, err error) {
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	now := float64(time.Now().UnixNano()) / float64(time.Second)
+	conn.Send("MULTI")
+	conn.Send("LLEN", q.name)
+	conn.Send("LLEN", q.notiKey)
+	conn.Send("HLEN", q.processingKey)
+	conn.Send("ZCOUNT", q.workersKey, now, "+inf")
+	conn.Send("LLEN", q.failedKey)
+	conn.Send("EXISTS", q.pausedKey)
+	reply, err := redis.Ints(conn.Do("EXEC"))
+	if err != nil {
+		return
+	}
+	if len(reply) != 6 {
+		return nil, InvalidRedisReplyError
+	}
+
+	return &QueueStats{
+		Name:       q.name,
+		Len:        reply[0],
+		NotiLen:    reply[1],
+		Processing: reply[2],
+		Workers:    reply[3],
+		Failed:     reply[4],
+		Paused:     reply[5] == 1,
+	}, nil
+}
+
+// Workers returns the IDs of the live workers of the queue.
+func (q *Queue) Workers() (ids []string, err error) {
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	now := float64(time.Now().UnixNano()) / float64(time.Second)
+	err = conn.Send("ZREMRANGEBYSCORE", q.workersKey, "-inf", now) // removes the workers died without calling die()
+	if err != nil {
+		return
+	}
+	return redis.Strings(conn.Do("ZRANGE", q.workersKey, 0, -1))
+}
+
+// Processing returns the tasks being processed, the keys are the IDs of their workers.
+// The tasks of dead workers are included until they are requeued by RequeueLost().
+func (q *Queue) Processing() (tasks map[string]Task, err error) {
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	values, err := redis.StringMap(conn.Do("HGETALL", q.processingKey))
+	if err != nil {
+		return
+	}
+
+	tasks = make(map[string]Task, len(values))
+	for workerID, data := range values {
+		tasks[workerID], err = DeserializeTask([]byte(data))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// Peek returns at most limit tasks from the offset of the queue without dequeuing them.
+// The returned tasks are GoTask or PyTask.
+func (q *Queue) Peek(offset, limit int) (tasks []Task, err error) {
+	if limit <= 0 {
+		return
+	}
+
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	values, err := redis.ByteSlices(conn.Do("LRANGE", q.name, offset, offset+limit-1))
+	if err != nil {
+		return
+	}
+
+	tasks = make([]Task, len(values))
+	for i, data := range values {
+		tasks[i], err = DeserializeTask(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// scan calls fn with the tasks of the queue in order, until fn returns false.
+// The queue may be changed by other clients during scanning, so some tasks might be skipped or visited twice.
+func (q *Queue) scan(fn func(task Task, data []byte) bool) error {
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	for offset := 0; ; offset += scanBatchSize {
+		values, err := redis.ByteSlices(conn.Do("LRANGE", q.name, offset, offset+scanBatchSize-1))
+		if err != nil {
+			return err
+		}
+
+		for _, data := range values {
+			task, err := DeserializeTask(data)
+			if err != nil {
+				return err
+			}
+			if !fn(task, data) {
+				return nil
+			}
+		}
+
+		if len(values) < scanBatchSize {
+			return nil
+		}
+	}
+}
+
+// Find returns the tasks of the queue which satisfy the predicate.
+// The returned tasks are GoTask or PyTask.
+func (q *Queue) Find(predicate func(Task) bool) (tasks []Task, err error) {
+	err = q.scan(func(task Task, _ []byte) bool {
+		if predicate(task) {
+			tasks = append(tasks, task)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Count returns the count of the tasks of the queue with the function path.
+func (q *Queue) Count(funcPath string) (count int, err error) {
+	err = q.scan(func(task Task, _ []byte) bool {
+		if task.FuncPath() == funcPath {
+			count++
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	return
+}
+
+// Remove removes a task from the queue by its ID, and removes a notification as well.
+// It returns false if the task is not found, eg: it has been dequeued.
+// PyTask has no ID, so it can't be removed.
+func (q *Queue) Remove(taskID string) (ok bool, err error) {
+	if taskID == "" {
+		return
+	}
+
+	var task Task
+	var data []byte
+	err = q.scan(func(t Task, d []byte) bool {
+		if t.ID() == taskID {
+			task = t
+			data = d
+			return false
+		}
+		return true
+	})
+	if err != nil || task == nil {
+		return
+	}
+
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	removed, err := redis.Int(q.removeScript.Do(conn, q.name, q.notiKey, data))
+	if err != nil {
+		return
+	}
+	if removed > 0 {
+		q.logger.Debug("Removed task.", "queue", q.name, "task_id", taskID, "func_path", task.FuncPath())
+	}
+	return removed > 0, nil
+}
+
 // Enqueue appends a task to the queue.
 func (q *Queue) Enqueue(task Task) (err error) {
+	return q.EnqueueContext(context.Background(), task)
+}
+
+// EnqueueContext appends a task to the queue.
+// The context is passed to the enqueue interceptors, eg: to inject the trace context into the task.
+func (q *Queue) EnqueueContext(ctx context.Context, task Task) (err error) {
+	if len(q.enqueueInterceptors) == 0 {
+		return q.enqueue(ctx, task)
+	}
+	return q.interceptEnqueue(ctx, task, func(ctx context.Context) error {
+		return q.enqueue(ctx, task)
+	})
+}
+
+func (q *Queue) enqueue(ctx context.Context, task Task) (err error) {
+	err = q.validate(ctx, task)
+	if err != nil {
+		q.logger.Error("Rejected invalid task.", "queue", q.name, "task_id", task.ID(), "func_path", task.FuncPath(), "error", err)
+		return
+	}
+
+	if t, ok := task.(*GoTask); ok && q.codec != nil && t.codec == nil && t.raw.Codec == "" {
+		t.SetCodec(q.codec)
+	}
+	if q.compressor != nil {
+		switch t := task.(type) {
+		case *GoTask:
+			if t.compressor == nil && len(t.data) == 0 {
+				t.SetCompression(q.compressor, q.compressionThreshold)
+			}
+		case *PyTask:
+			if t.compressor == nil && len(t.data) == 0 {
+				t.SetCompression(q.compressor, q.compressionThreshold)
+			}
+		}
+	}
+
+	if t, ok := task.(*GoTask); ok {
+		err = q.encrypt(t)
+		if err != nil {
+			q.logger.Error("Failed to encrypt payload.", "queue", q.name, "task_id", t.raw.ID, "func_path", t.raw.FuncPath, "error", err)
+			return
+		}
+		err = q.offload(ctx, t)
+		if err != nil {
+			q.logger.Error("Failed to offload payload.", "queue", q.name, "task_id", t.raw.ID, "func_path", t.raw.FuncPath, "error", err)
+			return
+		}
+	}
+
 	conn := q.redis.Get()
 	defer conn.Close()
 
 	data, err := task.Serialize()
 	if err != nil {
-		log.Errorf("Failed to serialize task %s: %v", task.getFuncPath(), err)
+		q.logger.Error("Failed to serialize task.", "queue", q.name, "task_id", task.ID(), "func_path", task.FuncPath(), "error", err)
+		return
+	}
+	data, err = q.sign(data)
+	if err != nil {
+		q.logger.Error("Failed to sign task.", "queue", q.name, "task_id", task.ID(), "func_path", task.FuncPath(), "error", err)
 		return
 	}
 
@@ -167,9 +516,10 @@ func (q *Queue) Enqueue(task Task) (err error) {
 		return
 	}
 
-	_, err = conn.Do("RPUSH", q.notiKey, 1)               // use Do() to combine Send(), Flush() and Receive()
-	if err == nil && log.IsEnabledFor(golog.DebugLevel) { // check log level before calling task.getFuncPath()
-		log.Debugf("Enqueued task %s.", task.getFuncPath())
+	_, err = conn.Do("RPUSH", q.notiKey, 1) // use Do() to combine Send(), Flush() and Receive()
+	if err == nil {
+		q.logger.Debug("Enqueued task.", "queue", q.name, "task_id", task.ID(), "func_path", task.FuncPath())
+		q.emitTaskEvent(EventTaskEnqueued, task)
 	}
 	return
 }
@@ -197,32 +547,85 @@ func (q *Queue) Dequeue() (task *GoTask, err error) {
 	}
 
 	if popped[0] == '1' { // redis encodes 1 into '1'
-		log.Debugf("Popped a task.")
+		q.logger.Debug("Popped a task.", "queue", q.name, "worker_id", q.workerID)
+		var r interface{}
+		r, err = q.dequeueScript.Do(conn, q.name, q.processingKey, q.revokedKey, q.pausedKey, q.notiKey, q.workerID)
+		if err != nil || r == nil { // the task may have been removed
+			return nil, err
+		}
+		if _, paused := r.(int64); paused { // the notification has been pushed back
+			q.logger.Debug("Queue is paused.", "queue", q.name, "worker_id", q.workerID)
+			time.Sleep(time.Duration(float64(q.dequeueTimeout) * float64(time.Second))) // avoids popping the notification again immediately
+			return nil, nil
+		}
+
+		var reply []interface{}
+		reply, err = redis.Values(r, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(reply) != 2 {
+			return nil, InvalidRedisReplyError
+		}
 		var data []byte
-		data, err = redis.Bytes(q.dequeueScript.Do(conn, q.name, q.processingKey, q.workerID))
+		var revokedCount int
+		data, err = redis.Bytes(reply[0], nil)
 		if err != nil {
 			return nil, err
 		}
-		task, err = DeserializeGoTask(data)
-		if err == nil {
-			log.Debugf("Dequeued task %s.", task.raw.FuncPath)
+		revokedCount, err = redis.Int(reply[1], nil)
+		if err != nil {
+			return nil, err
 		}
+
+		err = q.verify(data) // before decompressing the data, which may be a compression bomb
+		if err != nil {
+			q.rejectUnverified(conn, data, err)
+			return nil, nil
+		}
+		task, err = deserializeDequeuedTask(data)
+		if err != nil {
+			q.logger.Error("Failed to deserialize task.", "queue", q.name, "worker_id", q.workerID, "error", err)
+			return
+		}
+		if revokedCount > 0 && q.skipRevoked(conn, task) {
+			return nil, nil
+		}
+		q.logger.Debug("Dequeued task.", "queue", q.name, "worker_id", q.workerID, "task_id", task.raw.ID, "func_path", task.raw.FuncPath)
+		q.emitTaskEvent(EventTaskDequeued, task)
 		return
 	} else {
 		return nil, InvalidRedisReplyError
 	}
 }
 
+// requeue releases the currently dequeued task, and appends it to the end of the queue.
+func (q *Queue) requeue(task *GoTask) (err error) {
+	data, err := task.Serialize()
+	if err != nil {
+		return
+	}
+
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	_, err = q.requeueScript.Do(conn, q.name, q.notiKey, q.processingKey, q.workerID, data)
+	if err == nil {
+		q.emitTaskEvent(EventTaskDeferred, task)
+	}
+	return
+}
+
 // Release releases the currently dequeued task.
 // It should be called after finishing a task.
 func (q *Queue) Release() (err error) {
 	conn := q.redis.Get()
 	defer conn.Close()
 
-	log.Debugf("Releasing the task of worker %s.", q.workerID)
+	q.logger.Debug("Releasing task.", "queue", q.name, "worker_id", q.workerID)
 	_, err = conn.Do("HDEL", q.processingKey, q.workerID)
 	if err == nil {
-		log.Debugf("Released the task of worker %s.", q.workerID)
+		q.logger.Debug("Released task.", "queue", q.name, "worker_id", q.workerID)
 	}
 	return
 }
@@ -234,12 +637,40 @@ func (q *Queue) RequeueLost() (count int, err error) {
 	conn := q.redis.Get()
 	defer conn.Close()
 
-	count, err = redis.Int(q.requeueLostScript.Do(conn, q.name, q.notiKey, q.processingKey, q.workerID))
+	reply, err := redis.Values(q.requeueLostScript.Do(conn, q.name, q.notiKey, q.processingKey, q.workerID))
+	if err != nil {
+		return
+	}
+	if len(reply)%2 != 1 {
+		return 0, InvalidRedisReplyError
+	}
+
+	count, err = redis.Int(reply[0], nil)
+	if err != nil {
+		return
+	}
 	if count > 0 {
-		if count == 1 {
-			log.Debugf("Requeued 1 lost task.")
-		} else {
-			log.Debugf("Requeued %d lost tasks.", count)
+		q.logger.Debug("Requeued lost tasks.", "queue", q.name, "count", count)
+	}
+
+	if q.hasEventListeners() {
+		for i := 1; i < len(reply); i += 2 {
+			workerID, _ := redis.String(reply[i], nil)
+			data, _ := redis.Bytes(reply[i+1], nil)
+			q.emit(&Event{
+				Type:     EventWorkerDied,
+				WorkerID: workerID,
+			})
+
+			e := &Event{
+				Type:     EventTaskRequeued,
+				WorkerID: workerID,
+			}
+			if task, err := DeserializeGoTask(data); err == nil {
+				e.TaskID = task.raw.ID
+				e.FuncPath = task.raw.FuncPath
+			}
+			q.emit(e)
 		}
 	}
 	return
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/gomodule/redigo/redis"
+	"github.com/shamaton/msgpack/v2"
 )
 
 func TestNewQueue(t *testing.T) {
@@ -322,3 +323,250 @@ func BenchmarkQueueEnqueueAndDequeue(b *testing.B) {
 		}
 	}
 }
+
+func TestQueuePeek(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr))
+	defer q.Clear()
+
+	tasks := []Task{NewGoTask("test", 1), NewPyTask("test.f", []int{1}, nil), NewGoTask("test", 2)}
+	for _, task := range tasks {
+		err := q.Enqueue(task)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	peeked, err := q.Peek(1, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peeked) != 2 {
+		t.Fatalf("got %d tasks", len(peeked))
+	}
+	if _, ok := peeked[0].(*PyTask); !ok || peeked[0].FuncPath() != "test.f" {
+		t.Errorf("got %#v", peeked[0])
+	}
+	if task, ok := peeked[1].(*GoTask); !ok || task.ID() != tasks[2].ID() {
+		t.Errorf("got %#v", peeked[1])
+	}
+
+	count, err := q.Len()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.FailNow()
+	}
+}
+
+func TestQueueStatsAndWorkers(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2))
+	defer q.Clear()
+	w := NewWorker(q)
+
+	err := q.keepAlive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Enqueue(NewGoTask("test"))
+	q.Enqueue(NewGoTask("test"))
+	q.Dequeue()
+
+	stats, err := q.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := QueueStats{Name: "test", Len: 1, NotiLen: 1, Processing: 1, Workers: 1}
+	if *stats != want {
+		t.Errorf("got %+v, want %+v", *stats, want)
+	}
+
+	workers, err := q.Workers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(workers) != 1 || workers[0] != w.id {
+		t.Errorf("got workers %v", workers)
+	}
+
+	err = q.die()
+	if err != nil {
+		t.Fatal(err)
+	}
+	workers, err = q.Workers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(workers) != 0 {
+		t.Errorf("got workers %v", workers)
+	}
+}
+
+func TestQueueProcessing(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2))
+	defer q.Clear()
+	w := NewWorker(q)
+
+	task := NewGoTask("test", 1)
+	q.Enqueue(task)
+	q.Dequeue()
+
+	tasks, err := q.Processing()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[w.id] == nil || tasks[w.id].ID() != task.ID() {
+		t.Fatalf("got %v", tasks)
+	}
+
+	err = q.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks, err = q.Processing()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 0 {
+		t.Fatalf("got %v", tasks)
+	}
+}
+
+func TestQueueFindAndCount(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr))
+	defer q.Clear()
+
+	for i := 0; i < scanBatchSize+10; i++ {
+		q.Enqueue(NewGoTask("test.even", i))
+		q.Enqueue(NewGoTask("test.odd", i))
+	}
+	q.Enqueue(NewPyTask("test.py", []int{1}, nil))
+
+	tasks, err := q.Find(func(task Task) bool {
+		t, ok := task.(*GoTask)
+		if !ok {
+			return false
+		}
+		var arg int
+		return msgpack.Unmarshal(t.raw.Payload, &arg) == nil && arg >= scanBatchSize
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 20 {
+		t.Errorf("found %d tasks", len(tasks))
+	}
+
+	for funcPath, want := range map[string]int{"test.even": scanBatchSize + 10, "test.py": 1, "test.none": 0} {
+		count, err := q.Count(funcPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != want {
+			t.Errorf("counted %d tasks of %s, want %d", count, funcPath, want)
+		}
+	}
+}
+
+func TestQueueRemove(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2))
+	defer q.Clear()
+	NewWorker(q)
+
+	task1 := NewGoTask("test", 1)
+	task2 := NewGoTask("test", 2)
+	q.Enqueue(task1)
+	q.Enqueue(task2)
+
+	ok, err := q.Remove(task1.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("failed to remove the task")
+	}
+	ok, err = q.Remove(task1.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("removed the task twice")
+	}
+
+	stats, err := q.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Len != 1 || stats.NotiLen != 1 {
+		t.Fatalf("got %+v", stats)
+	}
+
+	// a worker has popped the notification of the task before it's removed
+	conn := q.redis.Get()
+	defer conn.Close()
+	_, err = conn.Do("LPOP", q.notiKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err = q.Remove(task2.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("failed to remove the task")
+	}
+	_, err = conn.Do("RPUSH", q.notiKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task != nil {
+		t.Fatalf("dequeued %#v", task)
+	}
+}
+
+func TestQueuePurge(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr), KeepFailed(10))
+	defer q.Clear()
+
+	task := NewGoTask("f", 1)
+	for _, err := range []error{
+		q.Enqueue(task),
+		q.Enqueue(NewGoTask("f", 2)),
+		q.addFailed(task, InvalidTaskError),
+		q.Pause(),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := q.Purge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := q.Len()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("got %d tasks", count)
+	}
+	paused, err := q.IsPaused()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !paused {
+		t.Error("the queue is resumed")
+	}
+	failed, err := q.Failed(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 {
+		t.Errorf("got %d failed tasks", len(failed))
+	}
+}
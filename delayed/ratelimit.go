@@ -0,0 +1,138 @@
+package delayed
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	rateKeySuffix = "_rate"
+
+	// maxDeferSleep is the max time a worker sleeps after deferring a task, so it won't dequeue the same task immediately.
+	maxDeferSleep = time.Second
+
+	// KEYS: bucket_key
+	// ARGV: rate (tokens per second), burst, now (ms)
+	// returns: the milliseconds to wait for a token, 0 if a token was taken
+	takeTokenScript = `local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call('hmget', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+    tokens = burst
+    ts = now
+end
+if now > ts then
+    tokens = math.min(burst, tokens + (now - ts) * rate / 1000)
+    ts = now
+end
+local wait = 0
+if tokens >= 1 then
+    tokens = tokens - 1
+else
+    wait = math.ceil((1 - tokens) * 1000 / rate)
+end
+redis.call('hmset', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(ts))
+redis.call('pexpire', KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return wait`
+)
+
+var takeToken = redis.NewScript(1, takeTokenScript)
+
+// rateLimit is a token bucket stored in Redis, shared by all the workers.
+type rateLimit struct {
+	key   string
+	rate  float64 // tokens per second
+	burst int
+}
+
+func newRateLimit(key string, rate float64, burst int) *rateLimit {
+	if rate <= 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimit{
+		key:   key,
+		rate:  rate,
+		burst: burst,
+	}
+}
+
+// take takes a token, or returns the time to wait for the next token.
+func (l *rateLimit) take(q *Queue) (wait time.Duration, err error) {
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	ms, err := redis.Int64(takeToken.Do(conn, l.key, strconv.FormatFloat(l.rate, 'f', -1, 64), l.burst, now))
+	if err != nil {
+		return
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// QueueRateLimit limits the tasks of the queue executed by all its workers to rate per second, with a burst size.
+// A worker waits for the limit after dequeuing a task.
+// It's not applied to tasks executed by Worker.Execute().
+func QueueRateLimit(rate float64, burst int) WorkerOption {
+	return func(w *Worker) {
+		w.queueRateLimit = newRateLimit(w.queue.name+rateKeySuffix, rate, burst)
+	}
+}
+
+// FuncRateLimit limits the tasks of the function path executed by all the workers of the queue to rate per second, with a burst size.
+// A task exceeds the limit is deferred to the end of the queue, then the worker sleeps until the next token is available (at most 1 second).
+// It's not applied to tasks executed by Worker.Execute().
+func FuncRateLimit(funcPath string, rate float64, burst int) WorkerOption {
+	return func(w *Worker) {
+		l := newRateLimit(w.queue.name+rateKeySuffix+":"+funcPath, rate, burst)
+		if l == nil {
+			delete(w.funcRateLimits, funcPath)
+			return
+		}
+		if w.funcRateLimits == nil {
+			w.funcRateLimits = map[string]*rateLimit{}
+		}
+		w.funcRateLimits[funcPath] = l
+	}
+}
+
+// limitRate waits for the rate limits of a dequeued task, and returns false if the task is deferred.
+// The limits are ignored if Redis fails, since a task shouldn't be lost.
+func (w *Worker) limitRate(t *GoTask) bool {
+	if l, ok := w.funcRateLimits[t.raw.FuncPath]; ok {
+		wait, err := l.take(w.queue)
+		if err != nil {
+			w.logger.Error("Failed to take token.", "queue", w.queue.name, "worker_id", w.id, "func_path", t.raw.FuncPath, "error", err)
+		} else if wait > 0 {
+			if wait > maxDeferSleep {
+				wait = maxDeferSleep
+			}
+			if w.deferTask(t, "rate limit", wait) == nil {
+				return false
+			}
+		}
+	}
+
+	if w.queueRateLimit != nil {
+		for {
+			wait, err := w.queueRateLimit.take(w.queue)
+			if err != nil {
+				w.logger.Error("Failed to take token.", "queue", w.queue.name, "worker_id", w.id, "error", err)
+				break
+			}
+			if wait == 0 {
+				break
+			}
+			time.Sleep(wait)
+		}
+	}
+	return true
+}
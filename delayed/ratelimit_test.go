@@ -0,0 +1,89 @@
+package delayed
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimitTake(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr))
+	l := newRateLimit(q.name+rateKeySuffix, 1, 2)
+	conn := q.redis.Get()
+	defer conn.Close()
+	conn.Do("DEL", l.key)
+	defer conn.Do("DEL", l.key)
+
+	for i := 0; i < 2; i++ {
+		wait, err := l.take(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait != 0 {
+			t.Fatalf("waited %v for token %d", wait, i)
+		}
+	}
+	wait, err := l.take(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("waited %v", wait)
+	}
+
+	if newRateLimit("test", 0, 1) != nil {
+		t.Error("created a rate limit with 0 rate")
+	}
+}
+
+func TestWorkerFuncRateLimit(t *testing.T) {
+	r := &eventRecorder{}
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2), OnEvent(r.handle))
+	defer q.Clear()
+	w := NewWorker(q, FuncRateLimit("test.limited", 100, 1), QueueRateLimit(1000, 10))
+	conn := q.redis.Get()
+	defer conn.Close()
+	for _, l := range []*rateLimit{w.queueRateLimit, w.funcRateLimits["test.limited"]} {
+		conn.Do("DEL", l.key)
+		defer conn.Do("DEL", l.key)
+	}
+
+	q.Enqueue(NewGoTask("test.limited", 1))
+	q.Enqueue(NewGoTask("test.limited", 2))
+	q.Enqueue(NewGoTask("test.unlimited", 3))
+
+	task, err := q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !w.limitRate(task) {
+		t.Fatal("the first task is deferred")
+	}
+	q.Release()
+
+	task, err = q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.events = nil
+	if w.limitRate(task) {
+		t.Fatal("the second task is not deferred")
+	}
+	assertEventTypes(t, r.types(), EventTaskDeferred)
+
+	task, err = q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.FuncPath() != "test.unlimited" || !w.limitRate(task) {
+		t.Fatalf("the unlimited task %#v is deferred", task)
+	}
+	q.Release()
+
+	stats, err := q.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Len != 1 || stats.NotiLen != 1 || stats.Processing != 0 {
+		t.Errorf("got %+v", stats)
+	}
+}
@@ -0,0 +1,193 @@
+package delayed
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+var (
+	InvalidHandlerError       = errors.New("Invalid handler")
+	UnregisteredFuncPathError = errors.New("Unregistered function path")
+)
+
+// Registry stores the handlers of an application, it can be shared by its workers and producers,
+// eg: defined in a package imported by both of them.
+// The workers load the handlers by Worker.LoadRegistry(),
+// and the producers check the args of the tasks against the arguments of the handlers by CheckArgs().
+type Registry struct {
+	lock     sync.RWMutex
+	handlers map[string]*Handler
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{handlers: map[string]*Handler{}}
+}
+
+// RegisterHandlers registers handlers by their function paths like Worker.RegisterHandlers().
+// It returns InvalidHandlerError if some of the funcs are not functions, the others are still registered.
+func (r *Registry) RegisterHandlers(funcs ...interface{}) (err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, f := range funcs {
+		h := NewHandler(f)
+		if h == nil {
+			err = fmt.Errorf("%w: %#v", InvalidHandlerError, f)
+			continue
+		}
+		r.handlers[h.path] = h
+	}
+	return
+}
+
+// RegisterHandlerAs registers a handler with an explicit name and its aliases like Worker.RegisterHandlerAs().
+// It returns InvalidHandlerError if f is not a function or the name is empty.
+func (r *Registry) RegisterHandlerAs(name string, f interface{}, aliases ...string) error {
+	fn := reflect.ValueOf(f)
+	var h *Handler
+	if fn.Kind() == reflect.Func {
+		h = newHandler(fn, name)
+	}
+	if h == nil {
+		return fmt.Errorf("%w: %s", InvalidHandlerError, name)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.handlers[name] = h
+	for _, alias := range aliases {
+		if alias != "" {
+			r.handlers[alias] = h
+		}
+	}
+	return nil
+}
+
+// handler returns the handler of the function path, or nil if it's not registered.
+func (r *Registry) handler(funcPath string) *Handler {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.handlers[funcPath]
+}
+
+// LoadRegistry registers all the handlers of the registry to the worker.
+// The handlers are copied, so the registry can be loaded by multiple workers running concurrently.
+func (w *Worker) LoadRegistry(r *Registry) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	copies := make(map[*Handler]*Handler, len(r.handlers)) // keeps the aliases sharing the same handler
+	for path, h := range r.handlers {
+		c, ok := copies[h]
+		if !ok {
+			c = newHandler(h.fn, h.path)
+			c.validators = h.validators
+			copies[h] = c
+		}
+		w.handlers[path] = c
+	}
+}
+
+// CheckArgs checks the args of the tasks enqueued to the queue against the arguments of their handlers in the registry,
+// so the tasks with incompatible args are rejected by the producer rather than failed by the worker.
+// Enqueue() returns an error wrapping IncompatibleArgsError if the count or the types of the args don't match,
+// or UnregisteredFuncPathError for a GoTask whose function is not registered. PyTasks of unregistered functions are not checked.
+func CheckArgs(r *Registry) QueueOption {
+	return func(q *Queue) {
+		q.registry = r
+	}
+}
+
+// check checks the args of a task against the arguments of its handler.
+func (r *Registry) check(task Task) error {
+	h := r.handler(task.FuncPath())
+	switch t := task.(type) {
+	case *GoTask:
+		if h == nil {
+			return fmt.Errorf("%w: %s", UnregisteredFuncPathError, t.raw.FuncPath)
+		}
+		if t.arg == nil { // deserialized, eg: a follow-up task of a chain
+			return h.Check(t)
+		}
+		return h.checkArgValues(t)
+	case *PyTask:
+		if h == nil {
+			return nil
+		}
+		return h.Check(t.goTask())
+	}
+	return nil
+}
+
+// checkArgValues checks the args of a GoTask which is not serialized yet.
+func (h *Handler) checkArgValues(t *GoTask) error {
+	codec := t.argCodec()
+	values := []interface{}{t.arg}
+	if t.multiArgs {
+		values, _ = t.arg.([]interface{})
+	} else if codec == MsgpackArrayCodec && h.argCount > 1 { // a slice is serialized as an array, which can be decoded as the args
+		v := reflect.ValueOf(t.arg)
+		if (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem().Kind() != reflect.Uint8 {
+			values = make([]interface{}, v.Len())
+			for i := range values {
+				values[i] = v.Index(i).Interface()
+			}
+		}
+	}
+
+	n := len(values)
+	if h.isVariadic {
+		if n < h.argCount-1 {
+			return fmt.Errorf("%w: %s takes at least %d arguments but %d were given", IncompatibleArgsError, h.path, h.argCount-1, n)
+		}
+	} else if n != h.argCount {
+		return fmt.Errorf("%w: %s takes %d arguments but %d were given", IncompatibleArgsError, h.path, h.argCount, n)
+	}
+
+	fnType := h.fn.Type()
+	first := fnType.NumIn() - h.argCount
+	for i, value := range values {
+		name := h.path + " arg"
+		if n > 1 || h.argCount > 1 {
+			name = h.path + " args[" + strconv.Itoa(i) + "]"
+		}
+
+		var err error
+		if !h.isVariadic || i < h.argCount-1 {
+			err = checkArgValue(codec, value, fnType.In(first+i), name)
+		} else {
+			last := fnType.In(fnType.NumIn() - 1)
+			if n == h.argCount && checkArgValue(codec, value, last, name) == nil { // the variadic args are passed as a slice
+				continue
+			}
+			err = checkArgValue(codec, value, last.Elem(), name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkArgValue checks if an arg serialized by the codec can be decoded into t.
+// It's not checked if the serialized arg can't be decoded without knowing its type, eg: serialized by GobCodec.
+func checkArgValue(codec Codec, v interface{}, t reflect.Type, name string) error {
+	if v == nil {
+		return nil
+	}
+
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var decoded interface{}
+	if codec.Unmarshal(data, &decoded) != nil {
+		return nil
+	}
+	return checkValue(decoded, t, name, codec != MsgpackArrayCodec)
+}
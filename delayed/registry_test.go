@@ -0,0 +1,189 @@
+package delayed
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type taggedArg struct {
+	Name  string `json:"name" msgpack:"name"`
+	Count int    `json:"count" msgpack:"count"`
+}
+
+func newTestRegistry(t *testing.T) *Registry {
+	r := NewRegistry()
+	for name, f := range map[string]interface{}{
+		"f1":  f1,
+		"f2":  f2,
+		"f3":  f3,
+		"f5":  f5,
+		"f6":  f6,
+		"f9":  f9,
+		"f11": f11,
+		"f12": f12,
+		"f13": f13,
+		"f14": f14,
+		"ctx": func(ctx context.Context, a int) {},
+		"tag": func(a taggedArg, t time.Time) {},
+		"any": func(a interface{}, b map[string]int) {},
+	} {
+		if err := r.RegisterHandlerAs(name, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func TestRegistryCheck(t *testing.T) {
+	r := newTestRegistry(t)
+	tests := []struct {
+		name string
+		task *GoTask
+		ok   bool
+	}{
+		{"struct", NewGoTask("f1", testArg{A: 1, B: "test"}), true},
+		{"pointer to struct", NewGoTask("f1", &testArg{A: 1}), true},
+		{"struct to pointer", NewGoTask("f2", testArg{A: 1}), true},
+		{"nil pointer", NewGoTask("f2", nil), true},
+		{"similar struct", NewGoTask("f1", reorderedArg{A: 1, B: "test"}), true},
+		{"wrong struct field", NewGoTask("f1", struct{ A string }{"1"}), false},
+		{"int", NewGoTask("f3", 1), true},
+		{"other number", NewGoTask("f3", 1.5), true},
+		{"string", NewGoTask("f3", "1"), false},
+		{"no args", NewGoTask("f5"), true},
+		{"unexpected arg", NewGoTask("f5", 1), false},
+		{"two args", NewGoTask("f6", 1, 2), true},
+		{"less args", NewGoTask("f6", 1), false},
+		{"more args", NewGoTask("f6", 1, 2, 3), false},
+		{"wrong second arg", NewGoTask("f6", 1, "2"), false},
+		{"slice and struct", NewGoTask("f9", []int{1}, testArg{A: 1}), true},
+		{"wrong slice element", NewGoTask("f9", []string{"1"}, testArg{A: 1}), false},
+		{"slice", NewGoTask("f11", []int{1, 2}), true},
+		{"array", NewGoTask("f11", [2]int{1, 2}), true},
+		{"variadic", NewGoTask("f12", 1, 2, 3), true},
+		{"variadic without args", NewGoTask("f12"), true},
+		{"variadic as slice", NewGoTask("f12", []int{1, 2, 3}), true},
+		{"wrong variadic", NewGoTask("f12", 1, "2"), false},
+		{"variadic after slice", NewGoTask("f13", []int{1}, 2, 3), true},
+		{"variadic slice after slice", NewGoTask("f13", []int{1}, []int{2, 3}), true},
+		{"missing slice before variadic", NewGoTask("f13"), false},
+		{"wrong variadic after struct", NewGoTask("f14", testArg{}, []int{1}, 2, "3"), false},
+		{"context", NewGoTask("ctx", 1), true},
+		{"wrong arg after context", NewGoTask("ctx", "1"), false},
+		{"tagged struct", NewGoTask("tag", taggedArg{Name: "test", Count: 1}, time.Now()), true},
+		{"tagged struct by map", NewGoTask("tag", map[string]interface{}{"name": "test", "count": 1}, time.Now()), true},
+		{"wrong tagged field", NewGoTask("tag", map[string]interface{}{"count": "1"}, time.Now()), false},
+		{"interface", NewGoTask("any", []int{1}, map[string]int{"a": 1}), true},
+		{"wrong map value", NewGoTask("any", 1, map[string]string{"a": "1"}), false},
+	}
+
+	for _, c := range []Codec{MsgpackMapCodec, MsgpackArrayCodec, JSONCodec} {
+		t.Run(c.Name(), func(t *testing.T) {
+			for _, tt := range tests {
+				if c == MsgpackArrayCodec && (tt.name == "similar struct" || tt.name == "tagged struct by map") {
+					continue // structs are decoded by position
+				}
+				if c == JSONCodec && strings.HasPrefix(tt.name, "tagged") {
+					continue // time.Time is encoded as a string
+				}
+
+				task := *tt.task
+				task.SetCodec(c)
+				err := r.check(&task)
+				if tt.ok {
+					if err != nil {
+						t.Errorf("%s: got error %v", tt.name, err)
+					}
+				} else if !errors.Is(err, IncompatibleArgsError) {
+					t.Errorf("%s: got error %v", tt.name, err)
+				} else if !strings.Contains(err.Error(), tt.task.FuncPath()) {
+					t.Errorf("%s: got error without the function path: %v", tt.name, err)
+				}
+			}
+		})
+	}
+
+	err := r.check(NewGoTask("f4", 1))
+	if !errors.Is(err, UnregisteredFuncPathError) {
+		t.Errorf("got error %v", err)
+	}
+	err = r.check(NewPyTask("f4", []interface{}{1}, nil))
+	if err != nil {
+		t.Errorf("got error %v", err)
+	}
+	err = r.check(NewPyTask("f1", nil, map[string]interface{}{"C": 1}))
+	if err == nil {
+		t.Error("got no error")
+	}
+
+	err = r.RegisterHandlers(f1, 1)
+	if !errors.Is(err, InvalidHandlerError) {
+		t.Errorf("got error %v", err)
+	}
+	err = r.RegisterHandlerAs("", f1)
+	if !errors.Is(err, InvalidHandlerError) {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestCheckArgs(t *testing.T) {
+	r := NewRegistry()
+	err := r.RegisterHandlerAs("f", func(a int) int { return a + 1 }, "g")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2), CheckArgs(r))
+	defer q.Clear()
+
+	err = q.Enqueue(NewGoTask("f", "1"))
+	if !errors.Is(err, IncompatibleArgsError) {
+		t.Errorf("got error %v", err)
+	}
+	err = q.Enqueue(NewGoTask("h", 1))
+	if !errors.Is(err, UnregisteredFuncPathError) {
+		t.Errorf("got error %v", err)
+	}
+	count, err := q.Len()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("enqueued %d tasks", count)
+	}
+
+	task, err := Chain(NewGoTask("f", 1), NewGoTask("g"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = q.Enqueue(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	workers := []*Worker{NewWorker(q), NewWorker(q)}
+	for _, w := range workers {
+		w.LoadRegistry(r)
+	}
+	if workers[0].handlers["f"] == r.handlers["f"] || workers[0].handlers["f"] == workers[1].handlers["f"] {
+		t.Error("the handler is shared")
+	}
+	if workers[0].handlers["f"] != workers[0].handlers["g"] {
+		t.Error("the alias is not loaded")
+	}
+
+	for i, funcPath := range []string{"f", "g"} { // the follow-up task is checked when enqueued by the worker
+		task, err := q.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task == nil || task.raw.FuncPath != funcPath {
+			t.Fatalf("got task %v", task)
+		}
+		workers[i].Execute(task)
+		q.Release()
+	}
+}
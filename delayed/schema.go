@@ -0,0 +1,429 @@
+package delayed
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/shamaton/msgpack/v2"
+)
+
+var IncompatibleArgsError = errors.New("Incompatible args")
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// IncompatibleTask is a queued task whose args can't be decoded by its handler.
+type IncompatibleTask struct {
+	Task  Task
+	Error error
+}
+
+// payload returns the payload of a task and its codec, the args of a PyTask are converted for the handler.
+func (h *Handler) payload(t *GoTask) (codec Codec, payload []byte, err error) {
+	if t.py != nil {
+		payload, err = h.pyPayload(t.py)
+		return MsgpackArrayCodec, payload, err
+	}
+	codec, err = codecOf(t.raw.Codec)
+	if t.raw.Encryption != "" {
+		if t.plaintext == nil {
+			return nil, nil, EncryptedPayloadError
+		}
+		return codec, t.plaintext, err
+	}
+	return codec, t.raw.Payload, err
+}
+
+// Check decodes the args of a task without calling the handler, and returns the error if they can't be decoded.
+// The error wraps IncompatibleArgsError if the args don't match the arguments of the handler.
+func (h *Handler) Check(t *GoTask) error {
+	if t.arg != nil { // not serialized yet
+		if _, err := t.Serialize(); err != nil {
+			return err
+		}
+	}
+
+	codec, payload, err := h.payload(t)
+	if err != nil || h.argCount == 0 {
+		return err
+	}
+	return h.decode(codec, payload, reflect.New(reflect.TypeOf(h.arg).Elem()).Interface())
+}
+
+// CheckQueue decodes the args of the tasks in the queue by the registered handlers without executing them,
+// and returns the tasks which can't be decoded. Tasks of unregistered functions are skipped.
+// Run it with the handlers of a new version before deploying it, to find out the queued tasks it can't execute.
+func (w *Worker) CheckQueue() (tasks []*IncompatibleTask, err error) {
+	err = w.queue.scan(func(task Task, _ []byte) bool {
+		var t *GoTask
+		switch task := task.(type) {
+		case *GoTask:
+			t = task
+		case *PyTask:
+			t = task.goTask()
+		default:
+			return true
+		}
+
+		h, ok := w.handlers[t.raw.FuncPath]
+		if ok {
+			e := w.queue.loadBlob(context.Background(), t)
+			if e == nil {
+				e = w.queue.decrypt(t)
+			}
+			if e == nil {
+				e = h.Check(t)
+			}
+			if e != nil {
+				tasks = append(tasks, &IncompatibleTask{Task: task, Error: e})
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// decode decodes the payload serialized by the codec into arg, which has the same type as h.arg.
+// The payloads serialized by MsgpackArrayCodec are checked against the arguments first,
+// because the fields of the modified structs would be silently misassigned by position.
+func (h *Handler) decode(codec Codec, payload []byte, arg interface{}) error {
+	if len(payload) == 0 {
+		return nil
+	}
+
+	if codec == MsgpackArrayCodec {
+		if h.hasStruct {
+			if err := h.checkArgs(payload); err != nil {
+				return err
+			}
+		}
+	} else if h.isVariadic {
+		if ok, err := h.decodeVariadic(codec, payload, arg); ok {
+			return err
+		}
+	}
+	return codec.Unmarshal(payload, arg)
+}
+
+// checkArgs checks if the payload serialized by MsgpackArrayCodec can be decoded into the arguments by position.
+func (h *Handler) checkArgs(payload []byte) error {
+	var v interface{}
+	err := msgpack.UnmarshalAsArray(payload, &v)
+	if err != nil {
+		return err
+	}
+
+	fnType := h.fn.Type()
+	first := fnType.NumIn() - h.argCount
+	if h.argCount == 1 {
+		return checkValue(v, fnType.In(first), "arg", false)
+	}
+
+	if v == nil {
+		return nil
+	}
+	values, ok := v.([]interface{})
+	if !ok {
+		return fmt.Errorf("%w: %s takes %d arguments but got %s", IncompatibleArgsError, h.path, h.argCount, typeName(v))
+	}
+	if len(values) != h.argCount {
+		return fmt.Errorf("%w: %s takes %d arguments but %d were given", IncompatibleArgsError, h.path, h.argCount, len(values))
+	}
+	for i, value := range values {
+		err = checkValue(value, fnType.In(first+i), "args["+strconv.Itoa(i)+"]", false)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkValue checks if v, which is decoded without knowing its type, can be decoded into t.
+// The fields of the structs are decoded by their names if byName is true, otherwise by position.
+func checkValue(v interface{}, t reflect.Type, name string, byName bool) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if v == nil || reflect.TypeOf(v) == t { // nil or ext types, eg: time.Time
+		return nil
+	}
+	if p := reflect.PtrTo(t); p.Implements(textUnmarshalerType) || p.Implements(jsonUnmarshalerType) { // decoded by itself
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if byName {
+			m, ok := stringMap(v)
+			if !ok {
+				break
+			}
+			for _, field := range structFields(t) {
+				value, ok := m[fieldKey(field, m)]
+				if !ok {
+					continue
+				}
+				if err := checkValue(value, field.Type, name+"."+field.Name, byName); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		values, ok := v.([]interface{})
+		if !ok {
+			break
+		}
+		fields := structFields(t)
+		if len(values) != len(fields) {
+			return fmt.Errorf("%w: %s (%s) has %d fields but %d were given", IncompatibleArgsError, name, t, len(fields), len(values))
+		}
+		for i, field := range fields {
+			if err := checkValue(values[i], field.Type, name+"."+field.Name, byName); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			switch v.(type) {
+			case []byte, string: // bytes may be encoded as a string, eg: base64 in JSON
+				return nil
+			}
+		}
+		values, ok := v.([]interface{})
+		if !ok {
+			break
+		}
+		for i, value := range values {
+			if err := checkValue(value, t.Elem(), name+"["+strconv.Itoa(i)+"]", byName); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		switch m := v.(type) {
+		case map[interface{}]interface{}:
+			for key, value := range m {
+				if err := checkValue(value, t.Elem(), fmt.Sprintf("%s[%v]", name, key), byName); err != nil {
+					return err
+				}
+			}
+			return nil
+		case map[string]interface{}:
+			for key, value := range m {
+				if err := checkValue(value, t.Elem(), fmt.Sprintf("%s[%v]", name, key), byName); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case reflect.String:
+		switch v.(type) {
+		case string, []byte:
+			return nil
+		}
+	case reflect.Bool:
+		if _, ok := v.(bool); ok {
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if typeName(v) == "number" {
+			return nil
+		}
+	default: // interfaces and other types decoded by msgpack itself
+		return nil
+	}
+	return fmt.Errorf("%w: %s should be %s but got %s", IncompatibleArgsError, name, t, typeName(v))
+}
+
+// structFields returns the fields of a struct serialized by msgpack, in the same order.
+func structFields(t reflect.Type) []reflect.StructField {
+	var fields []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Tag.Get("msgpack") == "-" { // unexported or ignored
+			continue
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// fieldKey returns the key of a struct field in m, which is a struct encoded by its field names or tags.
+func fieldKey(field reflect.StructField, m map[string]interface{}) string {
+	for _, tag := range []string{"msgpack", "json"} {
+		if key := strings.Split(field.Tag.Get(tag), ",")[0]; key != "" {
+			if _, ok := m[key]; ok {
+				return key
+			}
+		}
+	}
+	return field.Name
+}
+
+// hasStruct returns if t is or contains a struct type.
+func hasStruct(t reflect.Type, visited map[reflect.Type]bool) bool {
+	if visited[t] {
+		return false
+	}
+	visited[t] = true
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return true
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return hasStruct(t.Elem(), visited)
+	case reflect.Map:
+		return hasStruct(t.Key(), visited) || hasStruct(t.Elem(), visited)
+	}
+	return false
+}
+
+// typeName returns the name of the type of a value decoded without knowing its type.
+func typeName(v interface{}) string {
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "bool"
+	case reflect.Slice:
+		if _, ok := v.([]byte); ok {
+			return "bytes"
+		}
+		return "array"
+	case reflect.Map:
+		return "map"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// decodeVariadic decodes the multiple args serialized as a struct (see argsStruct()) for a variadic function,
+// the rest args are packed into the last argument.
+// It returns false if the payload is not such a struct, or it can be decoded without packing.
+func (h *Handler) decodeVariadic(codec Codec, payload []byte, arg interface{}) (bool, error) {
+	n := argsStructLen(codec, payload)
+	if n < h.argCount-1 || n == h.argCount && codec.Unmarshal(payload, arg) == nil {
+		return false, nil
+	}
+
+	fnType := h.fn.Type()
+	types := make([]reflect.Type, h.argCount)
+	for i := range types {
+		types[i] = fnType.In(fnType.NumIn() - h.argCount + i)
+	}
+	args, err := decodeArgs(codec, payload, n, types)
+	if err != nil {
+		return true, err
+	}
+
+	target := reflect.ValueOf(arg).Elem()
+	if h.argCount == 1 {
+		target.Set(args[0])
+		return true, nil
+	}
+	for i, a := range args {
+		target.Field(i).Set(a)
+	}
+	return true, nil
+}
+
+// decodeArgs decodes n args serialized as a struct (see argsStruct()).
+// The type of the ith arg is types[i], except the last type is a slice, which the rest args are packed into.
+func decodeArgs(codec Codec, payload []byte, n int, types []reflect.Type) ([]reflect.Value, error) {
+	last := len(types) - 1
+	fields := make([]reflect.StructField, n)
+	for i := range fields {
+		t := types[last].Elem()
+		if i < last {
+			t = types[i]
+		}
+		fields[i] = reflect.StructField{
+			Name: "F" + strconv.Itoa(i),
+			Type: t,
+		}
+	}
+	v := reflect.New(reflect.StructOf(fields))
+	if err := codec.Unmarshal(payload, v.Interface()); err != nil {
+		return nil, err
+	}
+
+	v = v.Elem()
+	args := make([]reflect.Value, len(types))
+	for i := 0; i < last; i++ {
+		args[i] = v.Field(i)
+	}
+	rest := reflect.MakeSlice(types[last], 0, n-last)
+	for i := last; i < n; i++ {
+		rest = reflect.Append(rest, v.Field(i))
+	}
+	args[last] = rest
+	return args, nil
+}
+
+// argsStructLen returns the count of the fields of a struct created by argsStruct() from the payload serialized by the codec.
+// It returns -1 if the payload is not such a struct.
+func argsStructLen(codec Codec, payload []byte) int {
+	var v interface{}
+	if codec.Unmarshal(payload, &v) != nil {
+		return -1
+	}
+	if args := argsOfStruct(v); args != nil {
+		return len(args)
+	}
+	return -1
+}
+
+// argsOfStruct converts a struct created by argsStruct(), which is decoded as a map without knowing its type, into the args.
+// It returns nil if v is not such a map, whose keys should be "F0", "F1"...
+func argsOfStruct(v interface{}) []interface{} {
+	m, ok := stringMap(v)
+	if !ok || len(m) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(m))
+	for i := range args {
+		value, ok := m["F"+strconv.Itoa(i)]
+		if !ok {
+			return nil
+		}
+		args[i] = value
+	}
+	return args
+}
+
+// stringMap converts a map decoded without knowing its type into a map keyed by strings.
+// It returns false if v is not a map or its keys are not all strings.
+func stringMap(v interface{}) (map[string]interface{}, bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return v, true
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			k, ok := key.(string)
+			if !ok {
+				return nil, false
+			}
+			m[k] = value
+		}
+		return m, true
+	}
+	return nil, false
+}
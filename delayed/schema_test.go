@@ -0,0 +1,179 @@
+package delayed
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type appendedArg struct {
+	A int
+	B string
+	C bool
+}
+
+func TestDefaultCodec(t *testing.T) {
+	task := NewGoTask("f1", testArg{A: 1, B: "test"})
+	_, err := task.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.raw.Codec != MsgpackMapCodec.Name() {
+		t.Fatalf("got codec %q", task.raw.Codec)
+	}
+
+	var arg appendedArg
+	err = task.DecodeArg(&arg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if arg.A != 1 || arg.B != "test" || arg.C {
+		t.Errorf("got arg %#v", arg)
+	}
+}
+
+func TestHandlerCheck(t *testing.T) {
+	arrayTask := func(arg ...interface{}) *GoTask {
+		task := NewGoTask("test", arg...)
+		task.SetCodec(MsgpackArrayCodec)
+		return task
+	}
+
+	tests := []struct {
+		name string
+		fn   interface{}
+		task *GoTask
+		ok   bool
+	}{
+		{"same struct", f1, arrayTask(testArg{A: 1, B: "test"}), true},
+		{"appended field", func(appendedArg) {}, arrayTask(testArg{A: 1, B: "test"}), false},
+		{"appended field by name", func(appendedArg) {}, NewGoTask("test", testArg{A: 1, B: "test"}), true},
+		{"reordered fields", func(reorderedArg) {}, arrayTask(testArg{A: 1, B: "test"}), false},
+		{"reordered fields by name", func(reorderedArg) {}, NewGoTask("test", testArg{A: 1, B: "test"}), true},
+		{"nested struct", f9, arrayTask([]int{1}, appendedArg{A: 1}), false},
+		{"slice of structs", func([]testArg) {}, arrayTask([]appendedArg{{A: 1}}), false},
+		{"nil struct", f2, arrayTask(nil), true},
+		{"more args", f6, arrayTask(1, 2, 3), false},
+		{"less args", f7, arrayTask([]testArg{{A: 1}}), false},
+		{"wrong type", f6, NewGoTask("test", 1, "2"), false},
+		{"py task", f1, NewPyTask("test", []interface{}{1}, map[string]interface{}{"B": "test"}).goTask(), true},
+		{"py task with unexpected kwarg", f1, NewPyTask("test", nil, map[string]interface{}{"C": true}).goTask(), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewHandler(tt.fn).Check(tt.task)
+			if tt.ok {
+				if err != nil {
+					t.Errorf("got error %v", err)
+				}
+			} else if err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+
+	err := NewHandler(func(appendedArg) {}).Check(arrayTask(testArg{A: 1, B: "test"}))
+	if !errors.Is(err, IncompatibleArgsError) {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestCodecVariadic(t *testing.T) {
+	for _, c := range []Codec{MsgpackArrayCodec, MsgpackMapCodec, JSONCodec} {
+		t.Run(c.Name(), func(t *testing.T) {
+			tests := []struct {
+				fn   interface{}
+				task *GoTask
+				want int
+			}{
+				{f12, NewGoTask("f12", 1, 2, 3), 6},
+				{f12, NewGoTask("f12", []int{1, 2, 3}), 6},
+				{f13, NewGoTask("f13", []int{1, 2}, []int{3, 4}), 10},
+				{f14, NewGoTask("f14", testArg{A: 1, B: "test"}, []int{1}, []int{2, 3}), 11},
+			}
+			if c != MsgpackArrayCodec { // the args are packed by their names
+				tests = append(tests, []struct {
+					fn   interface{}
+					task *GoTask
+					want int
+				}{
+					{f13, NewGoTask("f13", []int{1, 2}, 3, 4), 10},
+					{f13, NewGoTask("f13", []int{1, 2}, 3), 6},
+					{f14, NewGoTask("f14", testArg{A: 1, B: "test"}, []int{1}, 2, 3), 11},
+				}...)
+			}
+
+			for _, tt := range tests {
+				tt.task.SetCodec(c)
+				data, err := tt.task.Serialize()
+				if err != nil {
+					t.Fatal(err)
+				}
+				task, err := DeserializeGoTask(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				result, err := call(context.Background(), NewHandler(tt.fn), task)
+				if err != nil {
+					t.Fatalf("failed to call %s: %v", tt.task.FuncPath(), err)
+				}
+				if got := int(result[0].Int()); got != tt.want {
+					t.Errorf("%s got %d, want %d", tt.task.FuncPath(), got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestWorkerCheckQueue(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr))
+	defer q.Clear()
+
+	legacyTask := NewGoTask("f1", testArg{A: 1, B: "test"})
+	legacyTask.SetCodec(MsgpackArrayCodec)
+	tasks := []Task{
+		legacyTask,
+		NewGoTask("f1", testArg{A: 1, B: "test"}),
+		NewGoTask("f3", 1),
+		NewPyTask("f1", nil, map[string]interface{}{"D": 1}),
+	}
+	for _, task := range tasks {
+		err := q.Enqueue(task)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := NewWorker(q)
+	w.RegisterHandlerAs("f1", func(appendedArg) {})
+	incompatible, err := w.CheckQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(incompatible) != 2 {
+		t.Fatalf("got %d incompatible tasks", len(incompatible))
+	}
+	if incompatible[0].Task.ID() != legacyTask.ID() || !errors.Is(incompatible[0].Error, IncompatibleArgsError) {
+		t.Errorf("got incompatible task %#v", incompatible[0])
+	}
+	if _, ok := incompatible[1].Task.(*PyTask); !ok {
+		t.Errorf("got incompatible task %#v", incompatible[1])
+	}
+
+	r := &eventRecorder{}
+	q.eventHandlers = []EventHandler{r.handle}
+	called := 0
+	w.RegisterHandlerAs("f1", func(appendedArg) { called++ })
+	task, err := q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Execute(task)
+	q.Release()
+	if called != 0 {
+		t.Error("the incompatible task is executed")
+	}
+	assertEventTypes(t, r.types(), EventTaskDequeued, EventTaskFailed)
+}
@@ -0,0 +1,211 @@
+package delayed
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	semaphoreKeySuffix = "_semaphore:"
+
+	// contendedSleep is the time a worker sleeps after deferring a contended task, so it won't dequeue the same task immediately.
+	contendedSleep = 100 * time.Millisecond
+
+	// KEYS: semaphore_key
+	// ARGV: holder, limit, now (ms), expire_at (ms)
+	// returns: 1 if acquired, 0 if not
+	acquireSemaphoreScript = `redis.call('zremrangebyscore', KEYS[1], '-inf', ARGV[3])
+if redis.call('zscore', KEYS[1], ARGV[1]) or redis.call('zcard', KEYS[1]) < tonumber(ARGV[2]) then
+    redis.call('zadd', KEYS[1], ARGV[4], ARGV[1])
+    redis.call('pexpireat', KEYS[1], ARGV[4])
+    return 1
+end
+return 0`
+
+	// KEYS: semaphore_key1, semaphore_key2...
+	// ARGV: holder, expire_at (ms)
+	renewSemaphoresScript = `for i = 1, #KEYS do
+    if redis.call('zscore', KEYS[i], ARGV[1]) then
+        redis.call('zadd', KEYS[i], ARGV[2], ARGV[1])
+        redis.call('pexpireat', KEYS[i], ARGV[2])
+    end
+end`
+)
+
+var (
+	acquireSemaphore = redis.NewScript(1, acquireSemaphoreScript)
+	renewSemaphores  = redis.NewScript(-1, renewSemaphoresScript)
+)
+
+// KeyFunc returns the key of a task, tasks with the same key share a semaphore.
+type KeyFunc func(task *GoTask) (string, error)
+
+// semaphore limits the count of the running tasks across all the workers of a queue.
+// Its leases are held by the workers, and expire with the keep alive timeout unless they are renewed by the live workers.
+type semaphore struct {
+	prefix string
+	limit  int
+	key    KeyFunc // nil if all the tasks of the function path share a semaphore
+}
+
+func (s *semaphore) keyOf(task *GoTask) (string, error) {
+	if s.key == nil {
+		return s.prefix, nil
+	}
+	key, err := s.key(task)
+	if err != nil {
+		return "", err
+	}
+	return s.prefix + ":" + key, nil
+}
+
+// ConcurrencyLimit limits the count of the running tasks of the function path across all the workers of the queue.
+// A task exceeds the limit is deferred to the end of the queue, or fails if it can't be deferred (eg: Redis fails), it never runs without holding the semaphore.
+// It's not applied to tasks executed by Worker.Execute().
+func ConcurrencyLimit(funcPath string, limit int) WorkerOption {
+	return func(w *Worker) {
+		w.addSemaphore(funcPath, limit, nil)
+	}
+}
+
+// Mutex prevents the tasks of the function path with the same key from running concurrently across all the workers of the queue.
+// A contended task is deferred to the end of the queue, and a task fails if its key can't be got or it can't be deferred.
+// It's not applied to tasks executed by Worker.Execute().
+func Mutex(funcPath string, key KeyFunc) WorkerOption {
+	return func(w *Worker) {
+		if key != nil {
+			w.addSemaphore(funcPath, 1, key)
+		}
+	}
+}
+
+func (w *Worker) addSemaphore(funcPath string, limit int, key KeyFunc) {
+	if limit <= 0 {
+		return
+	}
+	if w.semaphores == nil {
+		w.semaphores = map[string][]*semaphore{}
+	}
+	w.semaphores[funcPath] = append(w.semaphores[funcPath], &semaphore{
+		prefix: w.queue.name + semaphoreKeySuffix + funcPath,
+		limit:  limit,
+		key:    key,
+	})
+}
+
+// heldSemaphores stores the keys of the semaphores held by a worker.
+type heldSemaphores struct {
+	mu   sync.Mutex
+	keys []interface{}
+}
+
+func (h *heldSemaphores) set(keys []interface{}) {
+	h.mu.Lock()
+	h.keys = keys
+	h.mu.Unlock()
+}
+
+func (h *heldSemaphores) get() []interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.keys
+}
+
+func (w *Worker) leaseExpireAt() int64 {
+	return time.Now().Add(time.Duration(float64(w.queue.keepAliveTimeout)*float64(time.Second))).UnixNano() / int64(time.Millisecond)
+}
+
+// acquireSemaphores acquires the semaphores of a dequeued task, and returns false if it's contended.
+// It returns an error if the key of the task can't be got.
+func (w *Worker) acquireSemaphores(t *GoTask) (ok bool, err error) {
+	semaphores := w.semaphores[t.raw.FuncPath]
+	if len(semaphores) == 0 {
+		return true, nil
+	}
+
+	keys := make([]interface{}, 0, len(semaphores))
+	for _, s := range semaphores {
+		if s.key != nil { // the key func may decode the arg
+			err = w.loadPayload(context.Background(), t)
+			if err != nil {
+				return false, fmt.Errorf("failed to load the payload: %v", err)
+			}
+		}
+
+		var key string
+		key, err = s.keyOf(t)
+		if err != nil {
+			return false, fmt.Errorf("failed to get the key of the semaphore: %v", err)
+		}
+
+		ok, err = w.acquireSemaphore(key, s.limit)
+		if err != nil {
+			w.logger.Error("Failed to acquire semaphore.", "queue", w.queue.name, "worker_id", w.id, "key", key, "error", err)
+			ok = false
+		}
+		if !ok {
+			w.held.set(keys)
+			w.releaseSemaphores()
+			return false, nil
+		}
+		keys = append(keys, key)
+	}
+	w.held.set(keys)
+	return true, nil
+}
+
+func (w *Worker) acquireSemaphore(key string, limit int) (bool, error) {
+	conn := w.queue.redis.Get()
+	defer conn.Close()
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	return redis.Bool(acquireSemaphore.Do(conn, key, w.id, limit, now, w.leaseExpireAt()))
+}
+
+// releaseSemaphores releases the semaphores held by the worker.
+func (w *Worker) releaseSemaphores() {
+	keys := w.held.get()
+	if len(keys) == 0 {
+		return
+	}
+	w.held.set(nil)
+
+	conn := w.queue.redis.Get()
+	defer conn.Close()
+
+	for _, key := range keys {
+		err := conn.Send("ZREM", key, w.id)
+		if err != nil {
+			w.logger.Error("Failed to release semaphore.", "queue", w.queue.name, "worker_id", w.id, "key", key, "error", err)
+			return
+		}
+	}
+	_, err := conn.Do("")
+	if err != nil {
+		w.logger.Error("Failed to release semaphores.", "queue", w.queue.name, "worker_id", w.id, "error", err)
+	}
+}
+
+// renewSemaphores extends the leases of the semaphores held by the worker.
+func (w *Worker) renewSemaphores() {
+	keys := w.held.get()
+	if len(keys) == 0 {
+		return
+	}
+
+	conn := w.queue.redis.Get()
+	defer conn.Close()
+
+	args := make([]interface{}, 0, len(keys)+3)
+	args = append(args, len(keys))
+	args = append(args, keys...)
+	args = append(args, w.id, w.leaseExpireAt())
+	_, err := renewSemaphores.Do(conn, args...)
+	if err != nil {
+		w.logger.Error("Failed to renew semaphores.", "queue", w.queue.name, "worker_id", w.id, "error", err)
+	}
+}
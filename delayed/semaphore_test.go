@@ -0,0 +1,174 @@
+package delayed
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func argKey(task *GoTask) (string, error) {
+	var id int
+	err := task.DecodeArg(&id)
+	if err != nil {
+		return "", err
+	}
+	if id < 0 {
+		return "", errors.New("invalid id")
+	}
+	return strconv.Itoa(id), nil
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	pool := NewRedisPool(redisAddr)
+	q1 := NewQueue("test", pool, DequeueTimeout(time.Millisecond*2))
+	defer q1.Clear()
+	q2 := NewQueue("test", pool, DequeueTimeout(time.Millisecond*2))
+	w1 := NewWorker(q1, ConcurrencyLimit("test.render", 1))
+	w2 := NewWorker(q2, ConcurrencyLimit("test.render", 1))
+	conn := pool.Get()
+	defer conn.Close()
+	key := q1.name + semaphoreKeySuffix + "test.render"
+	conn.Do("DEL", key)
+	defer conn.Do("DEL", key)
+
+	q1.Enqueue(NewGoTask("test.render", 1))
+	q1.Enqueue(NewGoTask("test.render", 2))
+	q1.Enqueue(NewGoTask("test.other", 3))
+
+	task, err := q1.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !w1.limit(task) {
+		t.Fatal("the first task is deferred")
+	}
+
+	r := &eventRecorder{}
+	q2.eventHandlers = []EventHandler{r.handle}
+	task, err = q2.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w2.limit(task) {
+		t.Fatal("the second task is not deferred")
+	}
+	assertEventTypes(t, r.types(), EventTaskDequeued, EventTaskDeferred)
+
+	task, err = q2.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.FuncPath() != "test.other" || !w2.limit(task) {
+		t.Fatalf("the unlimited task %#v is deferred", task)
+	}
+	w2.release()
+
+	w1.renewSemaphores()
+	score, err := redis.Int64(conn.Do("ZSCORE", key, w1.id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if score < time.Now().Add(time.Second*50).UnixNano()/int64(time.Millisecond) {
+		t.Errorf("got lease expiration %d", score)
+	}
+
+	w1.release()
+	task, err = q2.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.FuncPath() != "test.render" || !w2.limit(task) {
+		t.Fatalf("the task %#v is deferred after the semaphore was released", task)
+	}
+	w2.release()
+
+	count, err := redis.Int(conn.Do("ZCARD", key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("got %d holders", count)
+	}
+}
+
+func TestMutex(t *testing.T) {
+	pool := NewRedisPool(redisAddr)
+	q1 := NewQueue("test", pool)
+	defer q1.Clear()
+	q2 := NewQueue("test", pool)
+	w1 := NewWorker(q1, Mutex("test.sync", argKey))
+	r := &eventRecorder{}
+	w2 := NewWorker(q2, Mutex("test.sync", argKey))
+	q2.eventHandlers = []EventHandler{r.handle}
+
+	task1 := NewGoTask("test.sync", 1)
+	task1.Serialize()
+	task2 := NewGoTask("test.sync", 2)
+	task2.Serialize()
+	task3 := NewGoTask("test.sync", -1)
+	task3.Serialize()
+
+	ok, err := w1.acquireSemaphores(task1)
+	if err != nil || !ok {
+		t.Fatalf("failed to acquire the semaphore of task 1: %v", err)
+	}
+	defer w1.releaseSemaphores()
+	ok, err = w2.acquireSemaphores(task1)
+	if err != nil || ok {
+		t.Fatalf("acquired a held semaphore: %v", err)
+	}
+	ok, err = w2.acquireSemaphores(task2)
+	if err != nil || !ok {
+		t.Fatalf("failed to acquire the semaphore of task 2: %v", err)
+	}
+	w2.releaseSemaphores()
+
+	if w2.limit(task3) {
+		t.Fatal("executing a task without key")
+	}
+	assertEventTypes(t, r.types(), EventTaskFailed)
+
+	conn := pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("SET", q2.name, "not a list") // fails to defer the task
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.events = nil
+	if w2.limit(task1) {
+		t.Fatal("executing a contended task")
+	}
+	assertEventTypes(t, r.types(), EventTaskFailed)
+}
+
+func TestMutexOfLoadedPayload(t *testing.T) {
+	store := NewS3BlobStore(&memoryS3Client{}, "bucket", "")
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2), QueueEncryption(NewKeyring("k1", testEncryptionKey)), QueueBlobStore(store, 0))
+	defer q.Clear()
+	w := NewWorker(q, Mutex("test.sync", argKey))
+
+	err := q.Enqueue(NewGoTask("test.sync", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Release()
+	if task.raw.Blob == "" || task.raw.Encryption == "" {
+		t.Fatal("the payload is not offloaded or encrypted")
+	}
+
+	if !w.limit(task) {
+		t.Fatal("failed to acquire the semaphore")
+	}
+	defer w.releaseSemaphores()
+	keys := w.held.get()
+	if len(keys) != 1 || keys[0] != q.name+semaphoreKeySuffix+"test.sync:1" {
+		t.Errorf("got keys %v", keys)
+	}
+}
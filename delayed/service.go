@@ -0,0 +1,119 @@
+package delayed
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// serviceName returns the name of the type of a service, it returns an empty string for an unnamed type.
+func serviceName(svc interface{}) string {
+	t := reflect.TypeOf(svc)
+	if t == nil {
+		return ""
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+// methodError returns why a method can't be called as a handler, or nil if it can.
+// Like net/rpc, only the methods with suitable signatures are handlers: the arguments (except the leading context.Context)
+// can be decoded from a payload, and the results can be encoded for the follow-up tasks, only the last one can be an error.
+func methodError(m reflect.Method) error {
+	t := m.Type // the first argument is the receiver
+	for i := 1; i < t.NumIn(); i++ {
+		arg := t.In(i)
+		if i == 1 && arg == contextType {
+			continue
+		}
+		if !serializable(arg, true, map[reflect.Type]bool{}) {
+			return fmt.Errorf("%w: argument of type %s can't be decoded", InvalidHandlerError, arg)
+		}
+	}
+	for i := 0; i < t.NumOut(); i++ {
+		result := t.Out(i)
+		if result == errorType {
+			if i == t.NumOut()-1 {
+				continue
+			}
+			return fmt.Errorf("%w: error isn't the last result", InvalidHandlerError)
+		}
+		if !serializable(result, false, map[reflect.Type]bool{}) {
+			return fmt.Errorf("%w: result of type %s can't be encoded", InvalidHandlerError, result)
+		}
+	}
+	return nil
+}
+
+// serializable returns whether the values of the type can be serialized by MessagePack.
+// An interface can be encoded by its dynamic value, but only an empty interface can be decoded.
+func serializable(t reflect.Type, decoding bool, visited map[reflect.Type]bool) bool {
+	if visited[t] {
+		return true
+	}
+	visited[t] = true
+
+	switch t.Kind() {
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return false
+	case reflect.Interface:
+		return !decoding || t.NumMethod() == 0
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return serializable(t.Elem(), decoding, visited)
+	case reflect.Map:
+		return serializable(t.Key(), decoding, visited) && serializable(t.Elem(), decoding, visited)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath == "" && !serializable(f.Type, decoding, visited) { // the unexported fields are ignored
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// RegisterService registers the exported methods of a service as handlers, each one is named "Service.Method",
+// where "Service" is the name of the type of svc.
+// It allows handlers to use the dependencies injected into the service instead of global variables.
+// The methods with pointer receivers are registered only if svc is a pointer.
+// The methods which can't be handlers (see methodError()) are skipped and logged.
+// Use NewGoTaskOfMethod() to create the tasks.
+func (w *Worker) RegisterService(svc interface{}) {
+	name := serviceName(svc)
+	v := reflect.ValueOf(svc)
+	if name == "" || v.NumMethod() == 0 {
+		w.getLogger().Warn("Invalid service.", "queue", w.queueName(), "worker_id", w.id, "service", fmt.Sprintf("%#v", svc))
+		return
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		path := name + "." + m.Name
+		if err := methodError(m); err != nil {
+			w.getLogger().Warn("Skipped method of service.", "queue", w.queueName(), "worker_id", w.id, "func_path", path, "error", err)
+			continue
+		}
+		w.handlers[path] = newHandler(v.Method(i), path)
+	}
+}
+
+// NewGoTaskOfMethod creates a new GoTask of a method registered by Worker.RegisterService().
+// svc is only used to get the name and the method set of the service, so it can be a nil pointer of the service type.
+// It must be the same type (a pointer or not) as the one registered, since the methods with pointer receivers are
+// only registered for a pointer.
+// It returns nil if the service has no such exported method, or the method isn't registered as a handler.
+func NewGoTaskOfMethod(svc interface{}, method string, arg ...interface{}) *GoTask {
+	name := serviceName(svc)
+	if name == "" {
+		return nil
+	}
+
+	m, ok := reflect.TypeOf(svc).MethodByName(method)
+	if !ok || methodError(m) != nil {
+		return nil
+	}
+	return NewGoTask(name+"."+method, arg...)
+}
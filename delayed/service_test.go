@@ -0,0 +1,73 @@
+package delayed
+
+import (
+	"testing"
+)
+
+type Counter struct {
+	counts map[string]int
+}
+
+func (c *Counter) Add(key string, n int) {
+	c.counts[key] += n
+}
+
+func (c Counter) Get(key string) int {
+	return c.counts[key]
+}
+
+func (c *Counter) unexported() {}
+
+func (c *Counter) Subscribe(fn func(key string, n int)) {}
+
+func (c Counter) Check(key string) (error, bool) {
+	return nil, c.counts[key] > 0
+}
+
+func TestWorkerRegisterService(t *testing.T) {
+	w := NewWorker(NewQueue("test", NewRedisPool(redisAddr)))
+	c := &Counter{counts: map[string]int{}}
+	w.RegisterService(c)
+	w.RegisterService(struct{}{})
+	w.RegisterService(nil)
+	if len(w.handlers) != 2 {
+		t.Fatalf("registered %d handlers", len(w.handlers))
+	}
+	if _, ok := w.handlers["Counter.Add"]; !ok {
+		t.Fatal("Counter.Add is not registered")
+	}
+
+	task := NewGoTaskOfMethod((*Counter)(nil), "Add", "a", 2)
+	if task == nil || task.FuncPath() != "Counter.Add" {
+		t.Fatalf("got task %#v", task)
+	}
+	_, err := task.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Execute(task)
+	if c.counts["a"] != 2 {
+		t.Errorf("got count %d", c.counts["a"])
+	}
+
+	if NewGoTaskOfMethod(Counter{}, "Add") != nil {
+		t.Error("created the task of a method with pointer receiver, which isn't registered for a value")
+	}
+	if NewGoTaskOfMethod(Counter{}, "Get") == nil {
+		t.Error("failed to create the task of a method with value receiver")
+	}
+	for _, method := range []string{"unexported", "Unknown", "Subscribe", "Check"} {
+		if NewGoTaskOfMethod(c, method) != nil {
+			t.Errorf("created the task of invalid method %s", method)
+		}
+	}
+	if NewGoTaskOfMethod(nil, "Add") != nil {
+		t.Error("created the task of a nil service")
+	}
+
+	w = NewWorker(NewQueue("test", NewRedisPool(redisAddr)))
+	w.RegisterService(Counter{})
+	if len(w.handlers) != 1 || w.handlers["Counter.Get"] == nil {
+		t.Errorf("got handlers %v", w.handlers)
+	}
+}
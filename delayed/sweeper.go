@@ -3,8 +3,6 @@ package delayed
 import (
 	"sync/atomic"
 	"time"
-
-	"github.com/keakon/golog/log"
 )
 
 const defaultSweeperInterval = time.Minute
@@ -14,6 +12,7 @@ type Sweeper struct {
 	queues   []*Queue
 	interval time.Duration
 	status   uint32
+	logger   Logger
 }
 
 // NewSweeper creates a new sweeper.
@@ -21,6 +20,7 @@ func NewSweeper(queues ...*Queue) *Sweeper {
 	return &Sweeper{
 		queues:   queues,
 		interval: defaultSweeperInterval,
+		logger:   DefaultLogger,
 	}
 }
 
@@ -44,9 +44,11 @@ func (s *Sweeper) Run() {
 
 func (s *Sweeper) run() {
 	for _, queue := range s.queues {
-		_, err := queue.RequeueLost()
+		count, err := queue.RequeueLost()
 		if err != nil {
-			log.Error(err)
+			s.logger.Error("Failed to requeue lost tasks.", "queue", queue.name, "error", err)
+		} else if count > 0 {
+			s.logger.Info("Requeued lost tasks.", "queue", queue.name, "count", count)
 		}
 	}
 }
@@ -2,30 +2,51 @@ package delayed
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"reflect"
 	"runtime"
 
-	"github.com/keakon/golog/log"
 	"github.com/shamaton/msgpack/v2"
 )
 
+// ProtocolVersion is the version of the wire format described in PROTOCOL.md.
+const ProtocolVersion = 1
+
+var InvalidTaskError = errors.New("Invalid task")
+
 // Task is the interface of both GoTask and PyTask.
 type Task interface {
 	Serialize() ([]byte, error)
-	getFuncPath() string
+	ID() string
+	FuncPath() string
 }
 
 // RawGoTask store the fields need to be serialized for a GoTask.
 type RawGoTask struct {
-	FuncPath string
-	Payload  []byte // serialized arg
+	FuncPath   string
+	Payload    []byte            // serialized arg
+	ID         string            // appended to the end to keep compatible with tasks without it
+	Headers    map[string]string // metadata of the task, eg: the trace context
+	Workflow   *RawWorkflow      // follow-up tasks, see Chain() and Chord()
+	Codec      string            // the name of the codec of the payload, empty for MsgpackArrayCodec
+	Blob       string            // the key of the payload offloaded to the blob store, see QueueBlobStore()
+	Encryption string            // the ID of the key encrypting the payload, see QueueEncryption()
 }
 
 // GoTask store a RawGoTask and the serialized data.
 type GoTask struct {
-	raw  RawGoTask // make it unexported but can be serialized by MessagePack
-	arg  interface{}
-	data []byte // serialized data
+	raw       RawGoTask // make it unexported but can be serialized by MessagePack
+	arg       interface{}
+	multiArgs bool       // arg is a slice of multiple args
+	codec     Codec      // the codec to serialize arg, nil for DefaultCodec
+	data      []byte     // serialized data
+	py        *RawPyTask // the PyTask wrapped by this task, see Worker.RegisterHandlerAs()
+	plaintext []byte     // the decrypted payload
+	attempt   int        // see Attempt()
+
+	compressor           Compressor // compresses the serialized data if it's larger than compressionThreshold
+	compressionThreshold int
 }
 
 // NewGoTask creates a new GoTask by the function path.
@@ -39,8 +60,10 @@ func NewGoTask(funcPath string, arg ...interface{}) *GoTask {
 	return &GoTask{
 		raw: RawGoTask{
 			FuncPath: funcPath,
+			ID:       newTaskID(),
 		},
-		arg: a,
+		arg:       a,
+		multiArgs: len(arg) != 1,
 	}
 }
 
@@ -66,8 +89,10 @@ func NewGoTaskOfFunc(f interface{}, arg ...interface{}) *GoTask {
 	return &GoTask{
 		raw: RawGoTask{
 			FuncPath: funcPath,
+			ID:       newTaskID(),
 		},
-		arg: a,
+		arg:       a,
+		multiArgs: len(arg) != 1,
 	}
 }
 
@@ -84,38 +109,242 @@ func (t *GoTask) Serialize() (data []byte, err error) {
 	}
 
 	if t.arg != nil {
-		t.raw.Payload, err = msgpack.MarshalAsArray(t.arg)
+		t.raw.Codec = codecName(t.argCodec())
+		t.raw.Payload, err = t.marshalArg()
 		if err != nil {
-			log.Errorf("Failed to serialize task.arg: %v", err)
 			return
 		}
 	}
 
-	t.data, err = msgpack.MarshalAsArray(&t.raw)
+	data, err = msgpack.MarshalAsArray(&t.raw)
+	if err != nil {
+		return
+	}
+	t.data, err = compress(data, t.compressor, t.compressionThreshold)
 	if err != nil {
-		log.Errorf("Failed to serialize task.data: %v", err)
 		return
 	}
 	return t.data, nil
 }
 
-// DeserializeGoTask creates a new GoTask from the serialized data.
+// SetCompression compresses the serialized task by the compressor if it's larger than threshold bytes, see QueueCompression().
+// It should be called before the task is serialized.
+func (t *GoTask) SetCompression(c Compressor, threshold int) {
+	t.compressor = c
+	t.compressionThreshold = threshold
+	t.data = nil // needs to be serialized again
+}
+
+// argCodec returns the codec to serialize the arg.
+func (t *GoTask) argCodec() Codec {
+	if t.codec != nil {
+		return t.codec
+	}
+	return DefaultCodec
+}
+
+// marshalArg serializes the arg by the codec of the task.
+func (t *GoTask) marshalArg() ([]byte, error) {
+	c := t.argCodec()
+	if c == nil || c == MsgpackArrayCodec {
+		return msgpack.MarshalAsArray(t.arg)
+	}
+	if t.multiArgs {
+		args, _ := t.arg.([]interface{})
+		if len(args) == 0 {
+			return nil, nil
+		}
+		return c.Marshal(argsStruct(args))
+	}
+	return c.Marshal(t.arg)
+}
+
+// SetCodec sets the codec to serialize the args, the default one is set by QueueCodec() or DefaultCodec.
+// It should be called before the task is serialized, and it's ignored for a deserialized task.
+func (t *GoTask) SetCodec(c Codec) {
+	if t.arg == nil && len(t.raw.Payload) > 0 {
+		return
+	}
+	t.codec = c
+	t.data = nil // needs to be serialized again
+}
+
+// setPayload replaces the payload of the task, which is serialized by MsgpackArrayCodec.
+func (t *GoTask) setPayload(payload []byte) {
+	t.raw.Payload = payload
+	t.raw.Codec = ""
+	t.raw.Blob = ""
+	t.raw.Encryption = ""
+	t.arg = nil
+	t.codec = nil
+	t.data = nil
+	t.plaintext = nil
+}
+
+// setBlob replaces the payload of the task with the key of the blob which stores it.
+func (t *GoTask) setBlob(key string) {
+	t.raw.Payload = nil
+	t.raw.Blob = key
+	t.arg = nil
+	t.data = nil
+}
+
+// setEncryptedPayload replaces the payload of the task with the payload encrypted by the key of the ID.
+func (t *GoTask) setEncryptedPayload(keyID string, payload []byte) {
+	t.raw.Payload = payload
+	t.raw.Encryption = keyID
+	t.arg = nil
+	t.data = nil
+}
+
+// DeserializeGoTask creates a new GoTask from the serialized data, which is decompressed if it's compressed.
 func DeserializeGoTask(data []byte) (task *GoTask, err error) {
+	raw, c, err := decompress(data)
+	if err != nil {
+		return
+	}
+	return deserializeGoTask(data, raw, c)
+}
+
+// deserializeGoTask creates a new GoTask from the serialized data, raw is the data decompressed by the compressor.
+// The compressor is kept to compress the task again if it's modified, eg: by SetHeader().
+func deserializeGoTask(data, raw []byte, c Compressor) (task *GoTask, err error) {
 	t := &GoTask{
-		data: data,
+		data:       data,
+		compressor: c,
 	}
-	err = msgpack.UnmarshalAsArray(data, &t.raw)
+	err = msgpack.UnmarshalAsArray(raw, &t.raw)
 	if err != nil {
-		log.Errorf("Failed to deserialize task: %v", err)
 		return
 	}
 	return t, nil
 }
 
-func (t *GoTask) getFuncPath() string {
+// DeserializeTask creates a new GoTask or PyTask from the serialized data.
+// It's slower than DeserializeGoTask() and DeserializePyTask(), use them if the type of the task is known.
+func DeserializeTask(data []byte) (task Task, err error) {
+	raw, c, err := decompress(data)
+	if err != nil {
+		return
+	}
+
+	if n, _ := arrayLen(raw); n < 2 {
+		return nil, InvalidTaskError
+	}
+	if isPyTaskData(raw) {
+		return deserializePyTask(data, raw, c)
+	}
+	return deserializeGoTask(data, raw, c)
+}
+
+// MarshalJSON renders the task in JSON, the payload is decoded without knowing its type.
+func (t *GoTask) MarshalJSON() ([]byte, error) {
+	data := t.raw.Payload
+	codec := t.raw.Codec
+	if len(data) == 0 && t.arg != nil { // not serialized yet
+		var err error
+		data, err = t.marshalArg()
+		if err != nil {
+			return nil, err
+		}
+		codec = codecName(t.argCodec())
+	}
+
+	var payload interface{}
+	if len(data) > 0 && t.raw.Encryption == "" {
+		c, err := codecOf(codec)
+		if err == nil {
+			err = c.Unmarshal(data, &payload)
+		}
+		if err != nil {
+			if codec == "" {
+				return nil, err
+			}
+			payload = data // can't be decoded without knowing its type, eg: gob
+		} else if codec != "" {
+			if args := argsOfStruct(payload); args != nil { // multiple args
+				payload = args
+			}
+		}
+	}
+
+	return json.Marshal(struct {
+		ID         string            `json:"id,omitempty"`
+		FuncPath   string            `json:"func_path"`
+		Payload    interface{}       `json:"payload"`
+		Headers    map[string]string `json:"headers,omitempty"`
+		Codec      string            `json:"codec,omitempty"`
+		Blob       string            `json:"blob,omitempty"`
+		Encryption string            `json:"encryption,omitempty"`
+	}{
+		ID:         t.raw.ID,
+		FuncPath:   t.raw.FuncPath,
+		Payload:    toJSONValue(payload),
+		Headers:    t.raw.Headers,
+		Codec:      codec,
+		Blob:       t.raw.Blob,
+		Encryption: t.raw.Encryption,
+	})
+}
+
+// ID returns the ID of the task.
+// Tasks enqueued by old versions have no ID.
+func (t *GoTask) ID() string {
+	return t.raw.ID
+}
+
+// FuncPath returns the function path of the task.
+func (t *GoTask) FuncPath() string {
 	return t.raw.FuncPath
 }
 
+// DecodeArg decodes the payload of a serialized or deserialized task into v.
+// v should be a pointer to the only arg, or to a struct represents the args if the function has multiple args
+// (its fields should be named F0, F1... unless the codec is MsgpackArrayCodec), or to a slice of the multiple args.
+// It returns EncryptedPayloadError if the payload is encrypted and not decrypted by the worker, see QueueEncryption().
+func (t *GoTask) DecodeArg(v interface{}) error {
+	payload := t.raw.Payload
+	if t.raw.Encryption != "" {
+		if t.plaintext == nil {
+			return EncryptedPayloadError
+		}
+		payload = t.plaintext
+	}
+
+	c, err := codecOf(t.raw.Codec)
+	if err != nil {
+		return err
+	}
+
+	rv := reflect.ValueOf(v)
+	if c != MsgpackArrayCodec && rv.Kind() == reflect.Ptr && rv.Elem().Kind() == reflect.Slice {
+		if n := argsStructLen(c, payload); n >= 0 {
+			args, err := decodeArgs(c, payload, n, []reflect.Type{rv.Elem().Type()})
+			if err != nil {
+				return err
+			}
+			rv.Elem().Set(args[0])
+			return nil
+		}
+	}
+	return c.Unmarshal(payload, v)
+}
+
+// Headers returns the headers of the task, it may be nil.
+// It should be treated as read-only, use SetHeader() to modify it.
+func (t *GoTask) Headers() map[string]string {
+	return t.raw.Headers
+}
+
+// SetHeader sets a header of the task.
+func (t *GoTask) SetHeader(key, value string) {
+	if t.raw.Headers == nil {
+		t.raw.Headers = map[string]string{}
+	}
+	t.raw.Headers[key] = value
+	t.data = nil // needs to be serialized again
+}
+
 // RawPyTask store the fields need to be serialized for a PyTask.
 type RawPyTask struct {
 	FuncPath string
@@ -127,6 +356,9 @@ type RawPyTask struct {
 type PyTask struct {
 	raw  RawPyTask // make it unexported but can be serialized by MessagePack
 	data []byte    // serialized data
+
+	compressor           Compressor // compresses the serialized data if it's larger than compressionThreshold
+	compressionThreshold int
 }
 
 // NewPyTask creates a new PyTask by the function path.
@@ -143,15 +375,69 @@ func NewPyTask(funcPath string, args, kwArgs interface{}) *PyTask {
 // Serialize returns the serialized data of the task.
 func (t *PyTask) Serialize() (data []byte, err error) {
 	if t.data == nil {
-		t.data, err = msgpack.MarshalAsArray(&t.raw)
+		data, err = msgpack.MarshalAsArray(&t.raw)
+		if err != nil {
+			return
+		}
+		t.data, err = compress(data, t.compressor, t.compressionThreshold)
 		if err != nil {
-			log.Errorf("Failed to serialize task.data: %v", err)
 			return
 		}
 	}
 	return t.data, nil
 }
 
-func (t *PyTask) getFuncPath() string {
+// SetCompression compresses the serialized task by the compressor if it's larger than threshold bytes, see QueueCompression().
+// It should be called before the task is serialized.
+// The Python version can't decompress the tasks for now.
+func (t *PyTask) SetCompression(c Compressor, threshold int) {
+	t.compressor = c
+	t.compressionThreshold = threshold
+	t.data = nil // needs to be serialized again
+}
+
+// DeserializePyTask creates a new PyTask from the serialized data, which is decompressed if it's compressed.
+// Its Args and KwArgs are decoded without knowing their types.
+func DeserializePyTask(data []byte) (task *PyTask, err error) {
+	raw, c, err := decompress(data)
+	if err != nil {
+		return
+	}
+	return deserializePyTask(data, raw, c)
+}
+
+// deserializePyTask creates a new PyTask from the serialized data, raw is the data decompressed by the compressor.
+func deserializePyTask(data, raw []byte, c Compressor) (task *PyTask, err error) {
+	t := &PyTask{
+		data:       data,
+		compressor: c,
+	}
+	err = msgpack.UnmarshalAsArray(raw, &t.raw)
+	if err != nil {
+		return
+	}
+	return t, nil
+}
+
+// MarshalJSON renders the task in JSON.
+func (t *PyTask) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		FuncPath string      `json:"func_path"`
+		Args     interface{} `json:"args"`
+		KwArgs   interface{} `json:"kwargs"`
+	}{
+		FuncPath: t.raw.FuncPath,
+		Args:     toJSONValue(t.raw.Args),
+		KwArgs:   toJSONValue(t.raw.KwArgs),
+	})
+}
+
+// ID returns an empty string since a PyTask has no ID.
+func (t *PyTask) ID() string {
+	return ""
+}
+
+// FuncPath returns the function path of the task.
+func (t *PyTask) FuncPath() string {
 	return t.raw.FuncPath
 }
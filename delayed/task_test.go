@@ -1,6 +1,7 @@
 package delayed
 
 import (
+	"encoding/json"
 	"testing"
 )
 
@@ -155,3 +156,91 @@ func TestPyTaskSerialize(t *testing.T) {
 		})
 	}
 }
+
+func TestDeserializeTask(t *testing.T) {
+	tasks := []Task{
+		NewGoTask("test"),
+		NewGoTask("test", nil),
+		NewGoTask("test", tArg),
+		NewGoTask("test", 1, 2),
+		&GoTask{raw: RawGoTask{FuncPath: "test"}}, // task without ID
+		NewPyTask("test", nil, nil),
+		NewPyTask("test", []int{1}, nil),
+		NewPyTask("test", nil, map[string]string{"foo": "bar"}),
+	}
+
+	for _, task := range tasks {
+		data, err := task.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		task2, err := DeserializeTask(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch task.(type) {
+		case *GoTask:
+			if t2, ok := task2.(*GoTask); !ok || !task.(*GoTask).Equal(t2) || t2.ID() != task.ID() {
+				t.Errorf("got %#v, want %#v", task2, task)
+			}
+		case *PyTask:
+			if _, ok := task2.(*PyTask); !ok || task2.FuncPath() != task.FuncPath() {
+				t.Errorf("got %#v, want %#v", task2, task)
+			}
+		}
+	}
+
+	_, err := DeserializeTask([]byte{0x91, 0xa1, 't'}) // ["t"]
+	if err != InvalidTaskError {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestTaskMarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		task Task
+		want string
+	}{
+		{
+			name: "go task",
+			task: &GoTask{raw: RawGoTask{FuncPath: "test", ID: "1"}, arg: []interface{}{1, tArg, map[int]string{1: "a"}}, codec: MsgpackArrayCodec},
+			want: `{"id":"1","func_path":"test","payload":[1,[1,"test"],{"1":"a"}]}`,
+		},
+		{
+			name: "go task with multiple args",
+			task: &GoTask{raw: RawGoTask{FuncPath: "test", ID: "1"}, arg: []interface{}{1, tArg, map[int]string{1: "a"}}, multiArgs: true},
+			want: `{"id":"1","func_path":"test","payload":[1,{"A":1,"B":"test"},{"1":"a"}],"codec":"msgpack-map"}`,
+		},
+		{
+			name: "go task without arg",
+			task: &GoTask{raw: RawGoTask{FuncPath: "test"}},
+			want: `{"func_path":"test","payload":null}`,
+		},
+		{
+			name: "py task",
+			task: NewPyTask("test", []int{1}, map[string]string{"foo": "bar"}),
+			want: `{"func_path":"test","args":[1],"kwargs":{"foo":"bar"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.task.Serialize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			task, err := DeserializeTask(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.Marshal(task)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
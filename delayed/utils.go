@@ -3,15 +3,14 @@ package delayed
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
 	"io"
-
-	"github.com/keakon/golog/log"
 )
 
-// Recover recovers from a panic.
+// Recover recovers from a panic and logs it by DefaultLogger.
 func Recover() {
 	if p := recover(); p != nil {
-		log.Errorf("Got a panic: %v", p)
+		DefaultLogger.Error("Got a panic.", "panic", p)
 	}
 }
 
@@ -23,3 +22,28 @@ func RandHexString(size int) string {
 	}
 	return hex.EncodeToString(bs)
 }
+
+func newTaskID() string {
+	return RandHexString(8)
+}
+
+// toJSONValue converts a value decoded by MessagePack into a value can be marshaled by encoding/json.
+// The maps with non-string keys are converted to map[string]interface{}.
+func toJSONValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[fmt.Sprint(k)] = toJSONValue(val)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, val := range v {
+			s[i] = toJSONValue(val)
+		}
+		return s
+	default:
+		return v
+	}
+}
@@ -0,0 +1,224 @@
+package delayed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var (
+	InvalidArgsError        = errors.New("Invalid args")
+	InvalidValidatorError   = errors.New("Invalid validator")
+	DisallowedFuncPathError = errors.New("Disallowed function path")
+)
+
+// Validator can be implemented by the types of the args, the args are validated before being enqueued or executed.
+// It's called with a decoded arg, so it's not called for a nil pointer or interface.
+type Validator interface {
+	Validate() error
+}
+
+var validatorType = reflect.TypeOf((*Validator)(nil)).Elem()
+
+// AllowFuncPaths only allows enqueuing the tasks of the function paths to the queue, other tasks are rejected with DisallowedFuncPathError.
+// It prevents the producers from enqueuing tasks of the functions shouldn't be called asynchronously by mistake.
+func AllowFuncPaths(funcPaths ...string) QueueOption {
+	return func(q *Queue) {
+		if q.allowedFuncPaths == nil {
+			q.allowedFuncPaths = map[string]bool{}
+		}
+		for _, path := range funcPaths {
+			q.allowedFuncPaths[path] = true
+		}
+	}
+}
+
+// RegisterValidator registers a validator of the handler registered with the name (or its alias).
+// The validator takes the same arguments as the handler (except the context) and returns an error,
+// it's called with the decoded args after they are validated by their Validate() methods, and before the handler is called.
+// The task fails with an error wrapping InvalidArgsError if a validator returns an error.
+func (w *Worker) RegisterValidator(name string, f interface{}) {
+	h, ok := w.handlers[name]
+	if !ok {
+		w.getLogger().Warn("Validator of unregistered handler.", "queue", w.queueName(), "worker_id", w.id, "name", name)
+		return
+	}
+
+	fn := reflect.ValueOf(f)
+	if !h.acceptsValidator(fn) {
+		w.getLogger().Warn("Invalid validator.", "queue", w.queueName(), "worker_id", w.id, "name", name, "validator", fmt.Sprintf("%#v", f))
+		return
+	}
+	h.validators = append(h.validators, fn)
+}
+
+// RegisterValidator registers a validator of the GoTasks of the function path enqueued to the queue,
+// so the tasks with invalid args are rejected by the producer rather than failed by the worker.
+// The validator returns an error and takes the arguments of the function, which are decoded from the payload like a handler,
+// it can be the same validator registered to the worker. It returns InvalidValidatorError if f is not such a function.
+// The args implementing Validator are validated even if no validator is registered, and the validators should be registered before enqueuing tasks.
+func (q *Queue) RegisterValidator(funcPath string, f interface{}) error {
+	fn := reflect.ValueOf(f)
+	if fn.Kind() != reflect.Func || fn.Type().NumOut() != 1 || fn.Type().Out(0) != errorType || newHandler(fn, funcPath) == nil {
+		return InvalidValidatorError
+	}
+
+	if q.validators == nil {
+		q.validators = map[string]reflect.Value{}
+	}
+	q.validators[funcPath] = fn
+	return nil
+}
+
+// validate checks if a task can be enqueued to the queue.
+func (q *Queue) validate(ctx context.Context, task Task) error {
+	if q.allowedFuncPaths != nil && !q.allowedFuncPaths[task.FuncPath()] {
+		return fmt.Errorf("%w: %s", DisallowedFuncPathError, task.FuncPath())
+	}
+	if t, ok := task.(*GoTask); ok && q.encryptionKeys != nil {
+		if err := q.decrypt(t); err != nil { // eg: a follow-up task encrypted with the task carrying it
+			return err
+		}
+	}
+	if q.registry != nil {
+		if err := q.registry.check(task); err != nil {
+			return err
+		}
+	}
+
+	t, ok := task.(*GoTask)
+	if !ok {
+		return nil
+	}
+	if fn, ok := q.validators[t.raw.FuncPath]; ok {
+		h := newHandler(fn, t.raw.FuncPath) // a handler can't be called concurrently
+
+		// serializes a copy, so the codec and compression of the queue are still applied to the task
+		c := *t
+		if q.codec != nil && c.codec == nil && c.raw.Codec == "" {
+			c.codec = q.codec
+		}
+		_, err := c.Serialize()
+		if err != nil {
+			return err
+		}
+		codec, payload, err := h.payload(&c)
+		if err != nil {
+			return err
+		}
+		result, err := h.call(ctx, codec, payload)
+		if err != nil {
+			return err
+		}
+		if err = returnedError(result); err != nil {
+			return fmt.Errorf("%w: %s: %v", InvalidArgsError, t.raw.FuncPath, err)
+		}
+		return nil
+	}
+
+	if t.arg == nil { // deserialized tasks can't be validated without knowing the types of their args
+		return nil
+	}
+	args := []interface{}{t.arg}
+	if t.multiArgs {
+		args, _ = t.arg.([]interface{})
+	}
+	for i, arg := range args {
+		if err := validateValue(reflect.ValueOf(arg)); err != nil {
+			return fmt.Errorf("%w: %s args[%d]: %v", InvalidArgsError, t.raw.FuncPath, i, err)
+		}
+	}
+	return nil
+}
+
+// acceptsValidator checks if fn can validate the args of the handler.
+func (h *Handler) acceptsValidator(fn reflect.Value) bool {
+	if fn.Kind() != reflect.Func || fn.IsNil() {
+		return false
+	}
+	fnType := fn.Type()
+	if fnType.NumOut() != 1 || fnType.Out(0) != errorType || fnType.NumIn() != h.argCount || fnType.IsVariadic() != h.isVariadic {
+		return false
+	}
+
+	hType := h.fn.Type()
+	first := hType.NumIn() - h.argCount
+	for i := 0; i < h.argCount; i++ {
+		if fnType.In(i) != hType.In(first+i) {
+			return false
+		}
+	}
+	return true
+}
+
+// validate validates the decoded args by their Validate() methods and the registered validators.
+func (h *Handler) validate() error {
+	args := h.args
+	if h.hasContext {
+		args = args[1:]
+	}
+
+	if h.validatable {
+		for i, arg := range args {
+			var err error
+			if h.isVariadic && i == len(args)-1 {
+				for j := 0; j < arg.Len() && err == nil; j++ {
+					err = validateValue(arg.Index(j))
+				}
+			} else {
+				err = validateValue(arg)
+			}
+			if err != nil {
+				return fmt.Errorf("%w: %s args[%d]: %v", InvalidArgsError, h.path, i, err)
+			}
+		}
+	}
+
+	for _, v := range h.validators {
+		var result []reflect.Value
+		if h.isVariadic {
+			result = v.CallSlice(args)
+		} else {
+			result = v.Call(args)
+		}
+		if err := returnedError(result); err != nil {
+			return fmt.Errorf("%w: %s: %v", InvalidArgsError, h.path, err)
+		}
+	}
+	return nil
+}
+
+// validatable returns if the values of the type may implement Validator.
+func validatable(t reflect.Type) bool {
+	return t.Kind() == reflect.Interface || t.Implements(validatorType) || reflect.PtrTo(t).Implements(validatorType)
+}
+
+// validateValue calls the Validate() method of v or its pointer.
+func validateValue(v reflect.Value) error {
+	if !v.IsValid() {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+	}
+
+	if v.Type().Implements(validatorType) {
+		return v.Interface().(Validator).Validate()
+	}
+	if v.Kind() == reflect.Interface {
+		return validateValue(v.Elem())
+	}
+	if reflect.PtrTo(v.Type()).Implements(validatorType) {
+		if !v.CanAddr() {
+			p := reflect.New(v.Type())
+			p.Elem().Set(v)
+			v = p.Elem()
+		}
+		return v.Addr().Interface().(Validator).Validate()
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package delayed
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var negativeError = errors.New("negative")
+
+type positiveArg struct {
+	A int
+}
+
+func (a positiveArg) Validate() error {
+	if a.A <= 0 {
+		return negativeError
+	}
+	return nil
+}
+
+type positivePtrArg struct {
+	A int
+}
+
+func (a *positivePtrArg) Validate() error {
+	if a.A <= 0 {
+		return negativeError
+	}
+	return nil
+}
+
+func TestValidateValue(t *testing.T) {
+	var nilPtr *positivePtrArg
+	var v interface{} = positivePtrArg{A: -1}
+	tests := []struct {
+		name string
+		v    reflect.Value
+		ok   bool
+	}{
+		{"valid", reflect.ValueOf(positiveArg{A: 1}), true},
+		{"invalid", reflect.ValueOf(positiveArg{A: 0}), false},
+		{"pointer receiver", reflect.ValueOf(positivePtrArg{A: -1}), false},
+		{"pointer", reflect.ValueOf(&positivePtrArg{A: 1}), true},
+		{"nil pointer", reflect.ValueOf(nilPtr), true},
+		{"interface", reflect.ValueOf(&v).Elem(), false},
+		{"not validator", reflect.ValueOf(testArg{A: -1}), true},
+		{"invalid value", reflect.Value{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateValue(tt.v)
+			if tt.ok {
+				if err != nil {
+					t.Errorf("got error %v", err)
+				}
+			} else if err != negativeError {
+				t.Errorf("got error %v", err)
+			}
+		})
+	}
+}
+
+func TestHandlerValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		fn        interface{}
+		validator interface{}
+		task      *GoTask
+		ok        bool
+	}{
+		{"valid", func(positiveArg) {}, nil, NewGoTask("f", positiveArg{A: 1}), true},
+		{"invalid", func(positiveArg) {}, nil, NewGoTask("f", positiveArg{A: -1}), false},
+		{"pointer receiver", func(positivePtrArg) {}, nil, NewGoTask("f", positivePtrArg{A: -1}), false},
+		{"multiple args", func(int, *positivePtrArg) {}, nil, NewGoTask("f", 1, positivePtrArg{A: -1}), false},
+		{"variadic", func(...positiveArg) {}, nil, NewGoTask("f", positiveArg{A: 1}, positiveArg{A: -1}), false},
+		{"valid by validator", f6, func(a, b int) error { return nil }, NewGoTask("f", 1, 2), true},
+		{"invalid by validator", f6, func(a, b int) error { return negativeError }, NewGoTask("f", 1, 2), false},
+		{"variadic validator", f12, func(a ...int) error {
+			if len(a) > 2 {
+				return negativeError
+			}
+			return nil
+		}, NewGoTask("f", 1, 2, 3), false},
+		{"context", func(context.Context, int) {}, func(int) error { return negativeError }, NewGoTask("f", 1), false},
+		{"mismatched validator", f6, func(a int) error { return negativeError }, NewGoTask("f", 1, 2), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewWorker(NewQueue("test", NewRedisPool(redisAddr)))
+			w.RegisterHandlerAs("f", tt.fn)
+			if tt.validator != nil {
+				w.RegisterValidator("f", tt.validator)
+			}
+			_, err := tt.task.Serialize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = call(context.Background(), w.handlers["f"], tt.task)
+			if tt.ok {
+				if err != nil {
+					t.Errorf("got error %v", err)
+				}
+			} else if !errors.Is(err, InvalidArgsError) {
+				t.Errorf("got error %v", err)
+			}
+		})
+	}
+}
+
+func TestQueueValidate(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2), AllowFuncPaths("f", "g", "h"))
+	defer q.Clear()
+
+	err := q.RegisterValidator("g", func(a, b int) error {
+		if a > b {
+			return negativeError
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = q.RegisterValidator("h", func(a ...positiveArg) {})
+	if err != InvalidValidatorError {
+		t.Errorf("got error %v", err)
+	}
+
+	tests := []struct {
+		name string
+		task Task
+		err  error
+	}{
+		{"valid", NewGoTask("f", positiveArg{A: 1}), nil},
+		{"invalid", NewGoTask("f", positiveArg{A: -1}), InvalidArgsError},
+		{"pointer receiver", NewGoTask("f", 1, positivePtrArg{A: -1}), InvalidArgsError},
+		{"valid by validator", NewGoTask("g", 1, 2), nil},
+		{"invalid by validator", NewGoTask("g", 2, 1), InvalidArgsError},
+		{"py task", NewPyTask("h", []interface{}{1}, nil), nil},
+		{"disallowed", NewGoTask("i", positiveArg{A: 1}), DisallowedFuncPathError},
+		{"disallowed py task", NewPyTask("i", nil, nil), DisallowedFuncPathError},
+	}
+
+	enqueued := 0
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := q.Enqueue(tt.task)
+			if tt.err == nil {
+				if err != nil {
+					t.Fatalf("got error %v", err)
+				}
+				enqueued++
+			} else if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v", err)
+			}
+		})
+	}
+
+	count, err := q.Len()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != enqueued {
+		t.Errorf("enqueued %d tasks", count)
+	}
+}
+
+func TestQueueValidateWithOptions(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2), QueueCodec(JSONCodec), QueueCompression(GzipCompressor, 100))
+	defer q.Clear()
+
+	err := q.RegisterValidator("f", func(a []positiveArg) error {
+		if len(a) < 2 {
+			return negativeError
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large := make([]positiveArg, 100)
+	for i := range large {
+		large[i].A = 1
+	}
+	for _, funcPath := range []string{"f", "g"} { // with and without the validator
+		task := NewGoTask(funcPath, large)
+		err = q.Enqueue(task)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.raw.Codec != "json" {
+			t.Errorf("%s: got codec %q", funcPath, task.raw.Codec)
+		}
+		if data, _ := task.Serialize(); len(data) == 0 || data[0] < 0xc7 || data[0] > 0xc9 {
+			t.Errorf("%s: the task is not compressed: %x", funcPath, data)
+		}
+	}
+
+	err = q.Enqueue(NewGoTask("f", []positiveArg{{A: 1}}))
+	if !errors.Is(err, InvalidArgsError) {
+		t.Errorf("got error %v", err)
+	}
+}
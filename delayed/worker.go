@@ -1,13 +1,14 @@
 package delayed
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"os/signal"
+	"reflect"
 	"sync/atomic"
 	"syscall"
 	"time"
-
-	"github.com/keakon/golog/log"
 )
 
 const (
@@ -43,6 +44,16 @@ type Worker struct {
 	status            uint32
 	keepAliveDuration time.Duration
 	sigChan           chan os.Signal
+	running           runningTask
+
+	executeInterceptors []ExecuteInterceptor
+
+	queueRateLimit *rateLimit
+	funcRateLimits map[string]*rateLimit
+	semaphores     map[string][]*semaphore
+	held           heldSemaphores
+
+	logger Logger
 }
 
 // NewWorker creates a new worker.
@@ -54,6 +65,7 @@ func NewWorker(queue *Queue, options ...WorkerOption) *Worker {
 		queue:             queue,
 		handlers:          map[string]*Handler{},
 		keepAliveDuration: defaultKeepAliveDuration,
+		logger:            queue.logger,
 	}
 
 	for _, option := range options {
@@ -63,6 +75,16 @@ func NewWorker(queue *Queue, options ...WorkerOption) *Worker {
 	return worker
 }
 
+// ID returns the ID of the worker.
+func (w *Worker) ID() string {
+	return w.id
+}
+
+// Queue returns the queue of the worker.
+func (w *Worker) Queue() *Queue {
+	return w.queue
+}
+
 // RegisterHandlers registers handlers.
 // Tasks with function not been registered will be ignored.
 func (w *Worker) RegisterHandlers(funcs ...interface{}) {
@@ -71,14 +93,36 @@ func (w *Worker) RegisterHandlers(funcs ...interface{}) {
 		if h != nil {
 			w.handlers[h.path] = h
 		} else {
-			log.Warnf("%#v is not a valid handler", f)
+			w.getLogger().Warn("Invalid handler.", "queue", w.queueName(), "worker_id", w.id, "handler", fmt.Sprintf("%#v", f))
+		}
+	}
+}
+
+// RegisterHandlerAs registers a handler with an explicit name and its aliases (eg: the old names of a renamed handler),
+// tasks created by NewGoTask() with any of the names are handled by it.
+// Unlike RegisterHandlers(), it accepts closures and method values, and the name won't change if the function is renamed or moved.
+func (w *Worker) RegisterHandlerAs(name string, f interface{}, aliases ...string) {
+	fn := reflect.ValueOf(f)
+	var h *Handler
+	if fn.Kind() == reflect.Func {
+		h = newHandler(fn, name)
+	}
+	if h == nil {
+		w.getLogger().Warn("Invalid handler.", "queue", w.queueName(), "worker_id", w.id, "name", name, "handler", fmt.Sprintf("%#v", f))
+		return
+	}
+
+	w.handlers[name] = h
+	for _, alias := range aliases {
+		if alias != "" {
+			w.handlers[alias] = h
 		}
 	}
 }
 
 // Run starts the worker.
 func (w *Worker) Run() {
-	log.Debugf("Starting worker %s.", w.id)
+	w.logger.Debug("Starting worker.", "queue", w.queue.name, "worker_id", w.id)
 
 	atomic.StoreUint32(&w.status, StatusRunning)
 	defer func() { atomic.StoreUint32(&w.status, StatusStopped) }()
@@ -89,19 +133,47 @@ func (w *Worker) Run() {
 	w.registerSignals()
 	defer w.unregisterSignals()
 
+	stopListening := w.listen()
+	defer stopListening()
+
+	w.queue.emit(&Event{
+		Type:     EventWorkerStarted,
+		WorkerID: w.id,
+	})
+	defer w.queue.emit(&Event{
+		Type:     EventWorkerStopped,
+		WorkerID: w.id,
+	})
+
 	for atomic.LoadUint32(&w.status) == StatusRunning {
 		w.run()
 	}
 }
 
 func (w *Worker) run() {
-	defer Recover() // try recover() out of execute() to reduce its overhead
+	var task *GoTask
+	var startTime time.Time
+	defer func() { // try recover() out of execute() to reduce its overhead
+		if p := recover(); p != nil {
+			w.logger.Error("Got a panic.", "queue", w.queue.name, "worker_id", w.id, "panic", p)
+			if task != nil {
+				w.finish(task, time.Since(startTime), fmt.Errorf("panic: %v", p))
+				w.release()
+			}
+		}
+	}()
 
 	sleepTime := defaultSleepTime
 	for atomic.LoadUint32(&w.status) == StatusRunning {
-		task, err := w.queue.Dequeue()
+		var err error
+		task, err = w.queue.Dequeue()
 		if err != nil {
-			log.Errorf("Failed to dequeue task: %v", err)
+			w.logger.Error("Failed to dequeue task.", "queue", w.queue.name, "worker_id", w.id, "error", err, "sleep", sleepTime)
+			w.queue.emit(&Event{
+				Type:     EventDequeueFailed,
+				WorkerID: w.id,
+				Error:    err.Error(),
+			})
 			time.Sleep(sleepTime)
 			sleepTime *= 2
 			if sleepTime > maxSleepTime {
@@ -113,15 +185,22 @@ func (w *Worker) run() {
 		if task == nil {
 			continue
 		}
+		if !w.limit(task) {
+			task = nil
+			continue
+		}
 
-		w.Execute(task)
+		startTime = time.Now()
+		w.execute(task, startTime)
+		w.release()
+		task = nil
 	}
 }
 
 // Stop stops the worker.
 func (w *Worker) Stop() {
 	if atomic.LoadUint32(&w.status) == StatusRunning {
-		log.Debugf("Stopping worker %s.", w.id)
+		w.logger.Debug("Stopping worker.", "queue", w.queue.name, "worker_id", w.id)
 		atomic.StoreUint32(&w.status, StatusStopping)
 	}
 }
@@ -139,15 +218,191 @@ func (w *Worker) unregisterSignals() {
 
 // Execute executes a task.
 func (w *Worker) Execute(t *GoTask) {
+	w.execute(t, time.Now())
+}
+
+func (w *Worker) execute(t *GoTask, startTime time.Time) {
 	h, ok := w.handlers[t.raw.FuncPath]
 	if ok {
-		_, err := h.Call(t.raw.Payload)
-		if err != nil {
-			log.Errorf("Failed to execute task %s: %v", t.raw.FuncPath, err)
+		w.queue.countAttempt(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		w.running.start(t.raw.ID, cancel)
+		var result []reflect.Value
+		var err error
+		if len(w.executeInterceptors) == 0 {
+			result, err = w.call(ctx, h, t)
+		} else {
+			err = w.interceptExecute(ctx, t, func(ctx context.Context) (err error) {
+				result, err = w.call(ctx, h, t)
+				return
+			})
+		}
+		if t.raw.Workflow != nil {
+			if e := w.continueWorkflow(t, result, err); e != nil {
+				w.logger.Error("Failed to continue workflow.", "queue", w.queue.name, "worker_id", w.id, "task_id", t.raw.ID, "attempt", t.attempt, "error", e)
+			}
 		}
+		if err == nil {
+			if e := w.queue.deleteBlob(t); e != nil {
+				w.logger.Error("Failed to delete blob.", "queue", w.queue.name, "worker_id", w.id, "task_id", t.raw.ID, "attempt", t.attempt, "blob", t.raw.Blob, "error", e)
+			}
+		}
+		w.finish(t, time.Since(startTime), err)
 	} else {
-		log.Debugf("Ignore unregistered task: %s", t.raw.FuncPath)
+		w.getLogger().Debug("Ignore unregistered task.", "queue", w.queueName(), "worker_id", w.id, "task_id", t.raw.ID, "func_path", t.raw.FuncPath)
+	}
+}
+
+// call loads the payload of the task, and calls the handler.
+func (w *Worker) call(ctx context.Context, h *Handler, t *GoTask) (result []reflect.Value, err error) {
+	err = w.loadPayload(ctx, t)
+	if err != nil {
+		return
+	}
+	return call(ctx, h, t)
+}
+
+// loadPayload fetches the payload of the task if it's offloaded to the blob store, and decrypts it if it's encrypted.
+// The payload is only loaded once, so it can be called before computing the keys of the semaphores.
+func (w *Worker) loadPayload(ctx context.Context, t *GoTask) error {
+	err := w.queue.loadBlob(ctx, t)
+	if err != nil {
+		return err
+	}
+	return w.queue.decrypt(t)
+}
+
+func call(ctx context.Context, h *Handler, t *GoTask) (result []reflect.Value, err error) {
+	codec, payload, err := h.payload(t)
+	if err != nil {
+		return
+	}
+	result, err = h.call(ctx, codec, payload)
+	if err != nil {
+		return
+	}
+	return result, returnedError(result)
+}
+
+// finish logs and emits the result of a task.
+func (w *Worker) finish(t *GoTask, duration time.Duration, err error) {
+	if w.running.end() {
+		if e := w.queue.unrevoke(t.raw.ID); e != nil {
+			w.logger.Error("Failed to unrevoke task.", "queue", w.queue.name, "worker_id", w.id, "task_id", t.raw.ID, "attempt", t.attempt, "error", e)
+		}
+		if err != nil {
+			w.logger.Info("Canceled task.", "queue", w.queue.name, "worker_id", w.id, "task_id", t.raw.ID, "func_path", t.raw.FuncPath, "attempt", t.attempt, "duration", duration, "error", err)
+			w.clearAttempt(t)
+			w.queue.emit(&Event{
+				Type:     EventTaskCanceled,
+				WorkerID: w.id,
+				TaskID:   t.raw.ID,
+				FuncPath: t.raw.FuncPath,
+				Duration: duration,
+				Error:    err.Error(),
+			})
+			return
+		}
+	}
+
+	kept := false // the attempt count of a kept failed task is kept too, so it continues after the task is requeued
+	if err != nil {
+		w.getLogger().Error("Failed to execute task.", "queue", w.queueName(), "worker_id", w.id, "task_id", t.raw.ID, "func_path", t.raw.FuncPath, "attempt", t.attempt, "duration", duration, "error", err)
+		if w.queue != nil && w.queue.keepFailed > 0 {
+			if e := w.queue.addFailed(t, err); e != nil {
+				w.logger.Error("Failed to keep failed task.", "queue", w.queue.name, "worker_id", w.id, "task_id", t.raw.ID, "attempt", t.attempt, "error", e)
+			} else {
+				kept = true
+			}
+		}
+	}
+	if !kept {
+		w.clearAttempt(t)
+	}
+
+	if !w.queue.hasEventListeners() {
+		return
+	}
+
+	e := &Event{
+		Type:     EventTaskSucceeded,
+		WorkerID: w.id,
+		TaskID:   t.raw.ID,
+		FuncPath: t.raw.FuncPath,
+		Duration: duration,
+	}
+	if err != nil {
+		e.Type = EventTaskFailed
+		e.Error = err.Error()
+	}
+	w.queue.emit(e)
+}
+
+// limit applies the concurrency limits and the rate limits to a dequeued task, and returns false if it shouldn't be executed now.
+func (w *Worker) limit(t *GoTask) bool {
+	ok, err := w.acquireSemaphores(t)
+	if err != nil {
+		w.finish(t, 0, err)
+		w.release()
+		return false
+	}
+	if !ok {
+		err = w.deferTask(t, "concurrency limit", contendedSleep)
+		if err != nil { // a task can't be executed without holding its semaphores, so it fails rather than breaking the limit
+			w.finish(t, 0, err)
+			w.release()
+		}
+		return false
+	}
+
+	if !w.limitRate(t) {
+		w.releaseSemaphores()
+		return false
+	}
+	return true
+}
+
+// deferTask moves a dequeued task to the end of the queue, then sleeps for a while.
+// The task is still being processed by the worker if it returns an error.
+func (w *Worker) deferTask(t *GoTask, reason string, sleep time.Duration) error {
+	err := w.queue.requeue(t)
+	if err != nil {
+		w.logger.Error("Failed to defer task.", "queue", w.queue.name, "worker_id", w.id, "task_id", t.raw.ID, "reason", reason, "error", err)
+		return err
+	}
+	w.logger.Debug("Deferred task.", "queue", w.queue.name, "worker_id", w.id, "task_id", t.raw.ID, "func_path", t.raw.FuncPath, "reason", reason)
+	time.Sleep(sleep)
+	return nil
+}
+
+// clearAttempt deletes the attempt count of a finished task.
+func (w *Worker) clearAttempt(t *GoTask) {
+	if e := w.queue.clearAttempt(t); e != nil {
+		w.logger.Error("Failed to clear attempt.", "queue", w.queue.name, "worker_id", w.id, "task_id", t.raw.ID, "error", e)
+	}
+}
+
+func (w *Worker) release() {
+	w.releaseSemaphores()
+	err := w.queue.Release()
+	if err != nil {
+		w.logger.Error("Failed to release task.", "queue", w.queue.name, "worker_id", w.id, "error", err)
+	}
+}
+
+// getLogger returns the logger of the worker, or DefaultLogger if the worker was not created by NewWorker().
+func (w *Worker) getLogger() Logger {
+	if w.logger == nil {
+		return DefaultLogger
+	}
+	return w.logger
+}
+
+func (w *Worker) queueName() string {
+	if w.queue == nil {
+		return ""
 	}
+	return w.queue.name
 }
 
 // KeepAlive keeps the worker alive.
@@ -174,14 +429,15 @@ func (w *Worker) KeepAlive() {
 func (w *Worker) keepAlive() {
 	err := w.queue.keepAlive()
 	if err != nil {
-		log.Error(err)
+		w.logger.Error("Failed to keep alive.", "queue", w.queue.name, "worker_id", w.id, "error", err)
 	}
+	w.renewSemaphores()
 }
 
 // Die marks the worker as dead.
 func (w *Worker) Die() {
 	err := w.queue.die()
 	if err != nil {
-		log.Error(err)
+		w.logger.Error("Failed to mark worker as dead.", "queue", w.queue.name, "worker_id", w.id, "error", err)
 	}
 }
@@ -2,6 +2,7 @@ package delayed
 
 import (
 	"os"
+	"reflect"
 	"sync/atomic"
 	"syscall"
 	"testing"
@@ -47,6 +48,32 @@ func TestWorkerRegisterHandlers(t *testing.T) {
 	}
 }
 
+func TestWorkerRegisterHandlerAs(t *testing.T) {
+	w := NewWorker(NewQueue("test", NewRedisPool(redisAddr)))
+	var got []int
+	w.RegisterHandlerAs("append.v2", func(n int) {
+		got = append(got, n)
+	}, "append", "")
+	w.RegisterHandlerAs("", f1)
+	w.RegisterHandlerAs("invalid", 1)
+	w.RegisterHandlerAs("nil", (func())(nil))
+	if len(w.handlers) != 2 {
+		t.Fatalf("registered %d handlers", len(w.handlers))
+	}
+
+	for i, name := range []string{"append.v2", "append", "unknown"} {
+		task := NewGoTask(name, i)
+		_, err := task.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Execute(task)
+	}
+	if !reflect.DeepEqual(got, []int{0, 1}) {
+		t.Errorf("got %v", got)
+	}
+}
+
 func TestWorkerRun(t *testing.T) {
 	w := NewWorker(NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2)), KeepAliveDuration(time.Second))
 	w.RegisterHandlers(panicFunc, redisCall)
@@ -0,0 +1,506 @@
+package delayed
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/shamaton/msgpack/v2"
+)
+
+const (
+	workflowKeySuffix = "_workflow:"
+
+	// workflowExpiration is how long the state of a workflow is kept after its last update, in seconds.
+	workflowExpiration = 7 * 24 * 3600
+
+	graphField        = "graph"
+	stateFieldPrefix  = "state:"
+	resultFieldPrefix = "result:"
+	errorFieldPrefix  = "error:"
+
+	// KEYS: workflow_key
+	// ARGV: node, from_state, to_state, expiration
+	// returns: 1 if the state is changed, 0 if not
+	transitNodeScript = `if redis.call('hget', KEYS[1], 'state:' .. ARGV[1]) ~= ARGV[2] then
+    return 0
+end
+redis.call('hset', KEYS[1], 'state:' .. ARGV[1], ARGV[3])
+redis.call('hdel', KEYS[1], 'error:' .. ARGV[1])
+redis.call('expire', KEYS[1], ARGV[4])
+return 1`
+
+	// KEYS: workflow_key
+	// ARGV: node, state, result_field_prefix, result, expiration
+	// returns: 1 if recorded, 0 if the workflow doesn't exist or the node isn't enqueued (e.g. finished by a duplicated execution)
+	finishNodeScript = `if redis.call('hget', KEYS[1], 'state:' .. ARGV[1]) ~= 'enqueued' then
+    return 0
+end
+redis.call('hmset', KEYS[1], 'state:' .. ARGV[1], ARGV[2], ARGV[3] .. ARGV[1], ARGV[4])
+redis.call('expire', KEYS[1], ARGV[5])
+return 1`
+)
+
+var (
+	transitNode = redis.NewScript(1, transitNodeScript)
+	finishNode  = redis.NewScript(1, finishNodeScript)
+
+	InvalidWorkflowError  = errors.New("Invalid workflow")
+	WorkflowNotFoundError = errors.New("Workflow not found")
+)
+
+// NodeState is the state of a task in a workflow.
+type NodeState string
+
+const (
+	NodePending   NodeState = "pending"   // waiting for its dependencies
+	NodeEnqueued  NodeState = "enqueued"  // enqueued or being processed
+	NodeSucceeded NodeState = "succeeded" // finished without error
+	NodeFailed    NodeState = "failed"    // finished with an error, can be retried
+)
+
+// WorkflowState is the overall state of a workflow.
+type WorkflowState string
+
+const (
+	WorkflowRunning   WorkflowState = "running"   // some tasks are not finished, and none failed
+	WorkflowSucceeded WorkflowState = "succeeded" // all the tasks succeeded
+	WorkflowFailed    WorkflowState = "failed"    // some tasks failed, the tasks depend on them are blocked until retried
+)
+
+// rawWorkflowNode stores the fields need to be serialized for a node of a workflow.
+type rawWorkflowNode struct {
+	Name string
+	Deps []string
+	Task []byte // serialized task without the results of its dependencies
+}
+
+// Workflow is a directed acyclic graph of tasks, each task is enqueued after all its dependencies succeeded.
+// Its state is stored in Redis, so it can be inspected, retried and resumed by any process.
+type Workflow struct {
+	id    string
+	queue *Queue
+	key   string
+	nodes []*rawWorkflowNode
+	tasks map[string]*GoTask // the tasks added but not submitted yet
+}
+
+// NewWorkflow creates an empty workflow of the queue.
+func NewWorkflow(queue *Queue) *Workflow {
+	return newWorkflow(queue, RandHexString(16))
+}
+
+func newWorkflow(queue *Queue, id string) *Workflow {
+	return &Workflow{
+		id:    id,
+		queue: queue,
+		key:   queue.name + workflowKeySuffix + id,
+		tasks: map[string]*GoTask{},
+	}
+}
+
+// LoadWorkflow loads a submitted workflow by its ID.
+func LoadWorkflow(queue *Queue, id string) (*Workflow, error) {
+	wf := newWorkflow(queue, id)
+	conn := queue.redis.Get()
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("HGET", wf.key, graphField))
+	if err != nil {
+		if err == redis.ErrNil {
+			err = WorkflowNotFoundError
+		}
+		return nil, err
+	}
+	err = msgpack.UnmarshalAsArray(data, &wf.nodes)
+	if err != nil {
+		return nil, err
+	}
+	return wf, nil
+}
+
+// ID returns the ID of the workflow.
+func (wf *Workflow) ID() string {
+	return wf.id
+}
+
+// Add adds a task to the workflow, which depends on the tasks named by deps.
+// The dependencies should be added before, so there are no cycles.
+// A task without arg receives the result of its only dependency as its args like Chain(),
+// or the results of all its dependencies as a slice like Chord().
+// The task shouldn't be a chain or a chord, and shouldn't be modified after added.
+func (wf *Workflow) Add(name string, task *GoTask, deps ...string) error {
+	if name == "" || task == nil || task.raw.Workflow != nil || wf.tasks == nil {
+		return InvalidWorkflowError
+	}
+	if _, ok := wf.tasks[name]; ok {
+		return InvalidWorkflowError
+	}
+	for _, dep := range deps {
+		if _, ok := wf.tasks[dep]; !ok {
+			return InvalidWorkflowError
+		}
+	}
+
+	w := task.workflow()
+	w.ID = wf.id
+	w.Node = name
+	err := wf.queue.encrypt(task) // the task is stored in Redis before being enqueued
+	if err != nil {
+		return err
+	}
+	data, err := task.Serialize()
+	if err != nil {
+		return err
+	}
+	wf.tasks[name] = task
+	wf.nodes = append(wf.nodes, &rawWorkflowNode{
+		Name: name,
+		Deps: deps,
+		Task: data,
+	})
+	return nil
+}
+
+// Submit stores the workflow in Redis, and enqueues the tasks without dependencies.
+// A workflow can only be submitted once.
+func (wf *Workflow) Submit() error {
+	return wf.SubmitContext(context.Background())
+}
+
+// SubmitContext is like Submit, the ctx is passed to the enqueue interceptors.
+func (wf *Workflow) SubmitContext(ctx context.Context) error {
+	if len(wf.nodes) == 0 {
+		return EmptyWorkflowError
+	}
+	if wf.tasks == nil { // loaded
+		return InvalidWorkflowError
+	}
+
+	data, err := msgpack.MarshalAsArray(wf.nodes)
+	if err != nil {
+		return err
+	}
+
+	conn := wf.queue.redis.Get()
+	defer conn.Close()
+
+	args := make([]interface{}, 0, len(wf.nodes)*2+3)
+	args = append(args, wf.key, graphField, data)
+	for _, node := range wf.nodes {
+		args = append(args, stateFieldPrefix+node.Name, string(NodePending))
+	}
+	_, err = conn.Do("HMSET", args...)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Do("EXPIRE", wf.key, workflowExpiration)
+	if err != nil {
+		return err
+	}
+	wf.tasks = nil
+	wf.queue.logger.Debug("Submitted workflow.", "queue", wf.queue.name, "workflow_id", wf.id)
+
+	return wf.enqueueReady(ctx)
+}
+
+// NodeStatus is the status of a task in a workflow.
+type NodeStatus struct {
+	Name   string    `json:"name"`
+	Deps   []string  `json:"deps,omitempty"`
+	TaskID string    `json:"task_id"`
+	State  NodeState `json:"state"`
+	Error  string    `json:"error,omitempty"`
+	result []byte
+}
+
+// WorkflowStatus is the status of a workflow.
+type WorkflowStatus struct {
+	ID    string        `json:"id"`
+	State WorkflowState `json:"state"`
+	Nodes []*NodeStatus `json:"nodes"` // in the order they were added
+	nodes map[string]*NodeStatus
+}
+
+// Status returns the status of a submitted workflow.
+func (wf *Workflow) Status() (*WorkflowStatus, error) {
+	conn := wf.queue.redis.Get()
+	defer conn.Close()
+
+	values, err := redis.StringMap(conn.Do("HGETALL", wf.key))
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, WorkflowNotFoundError
+	}
+
+	status := &WorkflowStatus{
+		ID:    wf.id,
+		State: WorkflowSucceeded,
+		Nodes: make([]*NodeStatus, len(wf.nodes)),
+		nodes: make(map[string]*NodeStatus, len(wf.nodes)),
+	}
+	for i, node := range wf.nodes {
+		task, err := DeserializeGoTask(node.Task)
+		if err != nil {
+			return nil, err
+		}
+		ns := &NodeStatus{
+			Name:   node.Name,
+			Deps:   node.Deps,
+			TaskID: task.raw.ID,
+			State:  NodeState(values[stateFieldPrefix+node.Name]),
+			Error:  values[errorFieldPrefix+node.Name],
+		}
+		if result, ok := values[resultFieldPrefix+node.Name]; ok {
+			ns.result = []byte(result)
+		}
+		status.Nodes[i] = ns
+		status.nodes[node.Name] = ns
+	}
+
+	for _, ns := range status.Nodes {
+		switch ns.State {
+		case NodeFailed:
+			status.State = WorkflowFailed
+		case NodePending, NodeEnqueued:
+			if status.State == WorkflowSucceeded {
+				status.State = WorkflowRunning
+			}
+		}
+	}
+	return status, nil
+}
+
+// Result decodes the result of a succeeded task of the workflow into v.
+// v should be a pointer to the only result, or to a slice or struct represents the results if the function has multiple results.
+func (wf *Workflow) Result(name string, v interface{}) error {
+	conn := wf.queue.redis.Get()
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("HGET", wf.key, resultFieldPrefix+name))
+	if err != nil {
+		if err == redis.ErrNil {
+			err = WorkflowNotFoundError
+		}
+		return err
+	}
+	return msgpack.UnmarshalAsArray(data, v)
+}
+
+// Retry enqueues the failed tasks of the workflow again.
+// It returns the count of the retried tasks.
+func (wf *Workflow) Retry() (count int, err error) {
+	status, err := wf.Status()
+	if err != nil {
+		return
+	}
+
+	ctx := context.Background()
+	for _, ns := range status.Nodes {
+		if ns.State != NodeFailed {
+			continue
+		}
+		var ok bool
+		ok, err = wf.transit(ns.Name, NodeFailed, NodeEnqueued)
+		if err != nil {
+			return
+		}
+		if ok {
+			err = wf.enqueueNode(ctx, ns.Name, status)
+			if err != nil {
+				return
+			}
+			count++
+		}
+	}
+	return
+}
+
+// Resume enqueues the tasks whose dependencies succeeded but haven't been enqueued,
+// and the enqueued tasks which are neither in the queue nor being processed.
+// It's used to continue a workflow after a process crashed between finishing a task and enqueuing the next ones.
+// It returns the count of the enqueued tasks.
+func (wf *Workflow) Resume() (count int, err error) {
+	status, err := wf.Status()
+	if err != nil {
+		return
+	}
+
+	lost := map[string]string{} // task ID -> node name
+	for _, ns := range status.Nodes {
+		if ns.State == NodeEnqueued {
+			lost[ns.TaskID] = ns.Name
+		}
+	}
+	if len(lost) > 0 {
+		// checks the queue before the processing hash, so a task dequeued during scanning is still found
+		err = wf.queue.scan(func(task Task, data []byte) bool {
+			delete(lost, task.ID())
+			return len(lost) > 0
+		})
+		if err != nil {
+			return
+		}
+		var tasks map[string]Task
+		tasks, err = wf.queue.Processing()
+		if err != nil {
+			return
+		}
+		for _, task := range tasks {
+			delete(lost, task.ID())
+		}
+	}
+
+	ctx := context.Background()
+	if len(lost) > 0 {
+		status, err = wf.Status() // the lost tasks may be finished during checking
+		if err != nil {
+			return
+		}
+		for _, name := range lost {
+			if status.nodes[name].State == NodeEnqueued {
+				wf.queue.logger.Info("Requeuing lost workflow task.", "queue", wf.queue.name, "workflow_id", wf.id, "node", name)
+				err = wf.enqueueNode(ctx, name, status)
+				if err != nil {
+					return
+				}
+				count++
+			}
+		}
+	}
+
+	n, err := wf.enqueueReadyOf(ctx, status)
+	count += n
+	return
+}
+
+// enqueueReady enqueues the pending tasks whose dependencies succeeded.
+func (wf *Workflow) enqueueReady(ctx context.Context) error {
+	status, err := wf.Status()
+	if err != nil {
+		return err
+	}
+	_, err = wf.enqueueReadyOf(ctx, status)
+	return err
+}
+
+func (wf *Workflow) enqueueReadyOf(ctx context.Context, status *WorkflowStatus) (count int, err error) {
+	for _, node := range wf.nodes {
+		if status.nodes[node.Name].State != NodePending {
+			continue
+		}
+		ready := true
+		for _, dep := range node.Deps {
+			if status.nodes[dep].State != NodeSucceeded {
+				ready = false
+				break
+			}
+		}
+		if !ready {
+			continue
+		}
+
+		var ok bool
+		ok, err = wf.transit(node.Name, NodePending, NodeEnqueued)
+		if err != nil {
+			return
+		}
+		if !ok { // enqueued by another process
+			continue
+		}
+		err = wf.enqueueNode(ctx, node.Name, status)
+		if err != nil {
+			if _, e := wf.transit(node.Name, NodeEnqueued, NodePending); e != nil {
+				wf.queue.logger.Error("Failed to reset workflow task.", "queue", wf.queue.name, "workflow_id", wf.id, "node", node.Name, "error", e)
+			}
+			return
+		}
+		count++
+	}
+	return
+}
+
+// enqueueNode enqueues the task of a node, it receives the results of its dependencies if it has no arg.
+func (wf *Workflow) enqueueNode(ctx context.Context, name string, status *WorkflowStatus) error {
+	var node *rawWorkflowNode
+	for _, n := range wf.nodes {
+		if n.Name == name {
+			node = n
+			break
+		}
+	}
+	if node == nil {
+		return InvalidWorkflowError
+	}
+
+	task, err := DeserializeGoTask(node.Task)
+	if err != nil {
+		return err
+	}
+
+	if len(node.Deps) > 0 && !hasArg(task.raw.Payload) && task.raw.Blob == "" {
+		var payload []byte
+		if len(node.Deps) == 1 {
+			payload = status.nodes[node.Deps[0]].result
+		} else {
+			payload = appendArrayHeader(nil, len(node.Deps))
+			for _, dep := range node.Deps {
+				result := status.nodes[dep].result
+				if len(result) == 0 {
+					result = []byte{0xc0} // nil
+				}
+				payload = append(payload, result...)
+			}
+		}
+		if len(payload) > 0 {
+			task.setPayload(payload)
+		}
+	}
+	return wf.queue.EnqueueContext(ctx, task)
+}
+
+// transit changes the state of a node if it's in the from state.
+func (wf *Workflow) transit(name string, from, to NodeState) (bool, error) {
+	conn := wf.queue.redis.Get()
+	defer conn.Close()
+
+	return redis.Bool(transitNode.Do(conn, wf.key, name, string(from), string(to), workflowExpiration))
+}
+
+// completeNode records the result of a succeeded workflow task, and enqueues the tasks depend on it.
+// It does nothing if the task has been finished.
+func (q *Queue) completeNode(w *RawWorkflow, payload []byte) error {
+	if payload == nil {
+		payload = []byte{0xc0} // nil
+	}
+	ok, err := q.finishNode(w, NodeSucceeded, resultFieldPrefix, payload)
+	if err != nil || !ok {
+		return err
+	}
+
+	wf, err := LoadWorkflow(q, w.ID)
+	if err != nil {
+		return err
+	}
+	return wf.enqueueReady(context.Background())
+}
+
+// failNode records the error of a failed workflow task.
+// It does nothing if the task has been finished, so a succeeded task is never marked as failed.
+func (q *Queue) failNode(w *RawWorkflow, taskErr error) error {
+	_, err := q.finishNode(w, NodeFailed, errorFieldPrefix, taskErr.Error())
+	return err
+}
+
+func (q *Queue) finishNode(w *RawWorkflow, state NodeState, field string, value interface{}) (bool, error) {
+	conn := q.redis.Get()
+	defer conn.Close()
+
+	ok, err := redis.Bool(finishNode.Do(conn, q.name+workflowKeySuffix+w.ID, w.Node, string(state), field, value, workflowExpiration))
+	if err == nil && !ok {
+		q.logger.Warn("Workflow not found or task not enqueued.", "queue", q.name, "workflow_id", w.ID, "node", w.Node, "state", state)
+	}
+	return ok, err
+}
@@ -0,0 +1,242 @@
+package delayed
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var flakyFailures int32
+
+func double(n int) int {
+	return n * 2
+}
+
+func flaky(n int) (int, error) {
+	if atomic.AddInt32(&flakyFailures, -1) >= 0 {
+		return 0, errors.New("flaky")
+	}
+	return n, nil
+}
+
+// drain executes the tasks of the queue until it's empty.
+func drain(t *testing.T, q *Queue, w *Worker) (count int) {
+	for {
+		task, err := q.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task == nil {
+			return
+		}
+		w.Execute(task)
+		q.Release()
+		count++
+	}
+}
+
+func assertNodeStates(t *testing.T, wf *Workflow, state WorkflowState, states ...NodeState) {
+	t.Helper()
+	status, err := wf.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != state {
+		t.Errorf("got workflow state %s, want %s", status.State, state)
+	}
+	for i, ns := range status.Nodes {
+		if ns.State != states[i] {
+			t.Errorf("got state %s of node %s, want %s", ns.State, ns.Name, states[i])
+		}
+	}
+}
+
+func TestWorkflow(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2))
+	defer q.Clear()
+	w := NewWorker(q)
+	w.RegisterHandlers(sum, double, flaky)
+
+	wf := NewWorkflow(q)
+	conn := q.redis.Get()
+	defer conn.Close()
+	defer conn.Do("DEL", wf.key)
+	if wf.Submit() != EmptyWorkflowError {
+		t.Fatal("submitted an empty workflow")
+	}
+	if wf.Add("a", NewGoTaskOfFunc(sum, 1, 2), "b") != InvalidWorkflowError {
+		t.Fatal("added a node with an unknown dependency")
+	}
+	for _, err := range []error{
+		wf.Add("a", NewGoTaskOfFunc(sum, 1, 2)),
+		wf.Add("b", NewGoTaskOfFunc(double), "a"),
+		wf.Add("c", NewGoTaskOfFunc(flaky, 4)),
+		wf.Add("d", NewGoTaskOfFunc(sum), "b", "c"),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if wf.Add("a", NewGoTaskOfFunc(sum, 1)) != InvalidWorkflowError {
+		t.Fatal("added a duplicated node")
+	}
+
+	atomic.StoreInt32(&flakyFailures, 1)
+	err := wf.Submit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wf.Submit() != InvalidWorkflowError {
+		t.Fatal("submitted twice")
+	}
+	assertNodeStates(t, wf, WorkflowRunning, NodeEnqueued, NodePending, NodeEnqueued, NodePending)
+
+	if n := drain(t, q, w); n != 3 { // a, c, b
+		t.Fatalf("executed %d tasks", n)
+	}
+	loaded, err := LoadWorkflow(q, wf.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNodeStates(t, loaded, WorkflowFailed, NodeSucceeded, NodeSucceeded, NodeFailed, NodePending)
+	status, _ := loaded.Status()
+	if status.Nodes[2].Error != "flaky" {
+		t.Errorf("got error %q", status.Nodes[2].Error)
+	}
+
+	n, err := loaded.Retry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("retried %d tasks", n)
+	}
+	if n := drain(t, q, w); n != 2 { // c, d
+		t.Fatalf("executed %d tasks", n)
+	}
+	assertNodeStates(t, loaded, WorkflowSucceeded, NodeSucceeded, NodeSucceeded, NodeSucceeded, NodeSucceeded)
+
+	var result int
+	err = loaded.Result("d", &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != 10 { // (1 + 2) * 2 + 4
+		t.Errorf("got result %d", result)
+	}
+
+	_, err = LoadWorkflow(q, "unknown")
+	if err != WorkflowNotFoundError {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestWorkflowDuplicatedExecution(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2))
+	defer q.Clear()
+	w := NewWorker(q)
+	w.RegisterHandlers(sum, double)
+
+	wf := NewWorkflow(q)
+	conn := q.redis.Get()
+	defer conn.Close()
+	defer conn.Do("DEL", wf.key)
+	for _, err := range []error{
+		wf.Add("a", NewGoTaskOfFunc(sum, 1, 2)),
+		wf.Add("b", NewGoTaskOfFunc(double), "a"),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := wf.Submit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task, err := q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Execute(task)
+	q.Release()
+	if n := drain(t, q, w); n != 1 { // b
+		t.Fatalf("executed %d tasks", n)
+	}
+	w.Execute(task) // a is executed again, e.g. requeued after its worker was considered lost
+	assertNodeStates(t, wf, WorkflowSucceeded, NodeSucceeded, NodeSucceeded)
+	if n := drain(t, q, w); n != 0 {
+		t.Fatalf("b is executed %d times again", n)
+	}
+
+	err = q.failNode(task.raw.Workflow, errors.New("duplicated"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNodeStates(t, wf, WorkflowSucceeded, NodeSucceeded, NodeSucceeded)
+}
+
+func TestWorkflowResume(t *testing.T) {
+	q := NewQueue("test", NewRedisPool(redisAddr), DequeueTimeout(time.Millisecond*2))
+	defer q.Clear()
+	w := NewWorker(q)
+	w.RegisterHandlers(sum, double)
+
+	wf := NewWorkflow(q)
+	conn := q.redis.Get()
+	defer conn.Close()
+	defer conn.Do("DEL", wf.key)
+	wf.Add("a", NewGoTaskOfFunc(sum, 1, 2))
+	wf.Add("b", NewGoTaskOfFunc(double), "a")
+	err := wf.Submit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// loses the enqueued task
+	q.Clear()
+	n, err := wf.Resume()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("resumed %d tasks", n)
+	}
+	n, err = wf.Resume()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("resumed %d tasks twice", n)
+	}
+
+	// crashes before enqueuing the next task
+	task, err := q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = q.finishNode(task.raw.Workflow, NodeSucceeded, resultFieldPrefix, []byte{3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Release()
+	assertNodeStates(t, wf, WorkflowRunning, NodeSucceeded, NodePending)
+
+	n, err = wf.Resume()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("resumed %d tasks", n)
+	}
+	drain(t, q, w)
+	var result int
+	err = wf.Result("b", &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != 6 {
+		t.Errorf("got result %d", result)
+	}
+}
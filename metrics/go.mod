@@ -0,0 +1,25 @@
+module github.com/yizhisec/go-delayed/metrics
+
+go 1.19
+
+require (
+	github.com/prometheus/client_golang v1.15.1
+	github.com/yizhisec/go-delayed v0.0.0
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/gomodule/redigo v1.8.9 // indirect
+	github.com/keakon/golog v0.0.0-20230323022214-04d52304f8cd // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.4 // indirect
+	github.com/prometheus/client_model v0.3.0 // indirect
+	github.com/prometheus/common v0.42.0 // indirect
+	github.com/prometheus/procfs v0.9.0 // indirect
+	github.com/shamaton/msgpack/v2 v2.1.1 // indirect
+	golang.org/x/sys v0.7.0 // indirect
+	google.golang.org/protobuf v1.30.0 // indirect
+)
+
+replace github.com/yizhisec/go-delayed => ../
@@ -0,0 +1,201 @@
+// Package metrics exports metrics of go-delayed queues in the Prometheus format.
+package metrics
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/yizhisec/go-delayed/delayed"
+)
+
+const namespace = "delayed"
+
+// Exporter collects metrics from the events and the Redis keys of queues.
+// It uses its own registry, so nothing is registered to the default Prometheus registry.
+type Exporter struct {
+	registry *prometheus.Registry
+	handler  http.Handler
+
+	enqueued     *prometheus.CounterVec
+	processed    *prometheus.CounterVec
+	failed       *prometheus.CounterVec
+	canceled     *prometheus.CounterVec
+	deferred     *prometheus.CounterVec
+	duration     *prometheus.HistogramVec
+	requeued     *prometheus.CounterVec
+	dequeueFails *prometheus.CounterVec
+
+	queueLen      *prometheus.Desc
+	processingLen *prometheus.Desc
+	paused        *prometheus.Desc
+
+	mu     sync.RWMutex
+	queues []*delayed.Queue
+}
+
+// NewExporter creates a new exporter.
+func NewExporter() *Exporter {
+	e := &Exporter{
+		registry: prometheus.NewRegistry(),
+		enqueued: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tasks_enqueued_total",
+			Help:      "Total number of enqueued tasks.",
+		}, []string{"queue", "func_path"}),
+		processed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tasks_processed_total",
+			Help:      "Total number of executed tasks, including the failed ones.",
+		}, []string{"queue", "func_path"}),
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tasks_failed_total",
+			Help:      "Total number of failed tasks.",
+		}, []string{"queue", "func_path"}),
+		canceled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tasks_canceled_total",
+			Help:      "Total number of canceled tasks, including the revoked ones skipped by workers.",
+		}, []string{"queue", "func_path"}),
+		deferred: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tasks_deferred_total",
+			Help:      "Total number of tasks moved to the end of the queue, eg: by rate limits.",
+		}, []string{"queue", "func_path"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "task_duration_seconds",
+			Help:      "Execution time of tasks.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"queue", "func_path"}),
+		requeued: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "lost_tasks_requeued_total",
+			Help:      "Total number of lost tasks requeued from dead workers.",
+		}, []string{"queue"}),
+		dequeueFails: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "dequeue_errors_total",
+			Help:      "Total number of dequeue errors, each of them makes the worker back off.",
+		}, []string{"queue"}),
+		queueLen: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "queue_length"),
+			"Number of tasks waiting in the queue.",
+			[]string{"queue"}, nil,
+		),
+		processingLen: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "processing_tasks"),
+			"Number of tasks being processed by workers.",
+			[]string{"queue"}, nil,
+		),
+		paused: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "queue_paused"),
+			"Whether the queue is paused (1) or not (0).",
+			[]string{"queue"}, nil,
+		),
+	}
+	e.registry.MustRegister(e)
+	e.handler = promhttp.HandlerFor(e.registry, promhttp.HandlerOpts{})
+	return e
+}
+
+// Watch adds queues whose length, processing task count and paused state should be collected.
+func (e *Exporter) Watch(queues ...*delayed.Queue) {
+	e.mu.Lock()
+	e.queues = append(e.queues, queues...)
+	e.mu.Unlock()
+}
+
+// HandleEvent updates the metrics by an event.
+// It should be added to a queue by delayed.OnEvent().
+func (e *Exporter) HandleEvent(ev *delayed.Event) {
+	switch ev.Type {
+	case delayed.EventTaskEnqueued:
+		e.enqueued.WithLabelValues(ev.Queue, ev.FuncPath).Inc()
+	case delayed.EventTaskSucceeded:
+		e.processed.WithLabelValues(ev.Queue, ev.FuncPath).Inc()
+		e.duration.WithLabelValues(ev.Queue, ev.FuncPath).Observe(ev.Duration.Seconds())
+	case delayed.EventTaskFailed:
+		e.processed.WithLabelValues(ev.Queue, ev.FuncPath).Inc()
+		e.failed.WithLabelValues(ev.Queue, ev.FuncPath).Inc()
+		e.duration.WithLabelValues(ev.Queue, ev.FuncPath).Observe(ev.Duration.Seconds())
+	case delayed.EventTaskCanceled:
+		e.canceled.WithLabelValues(ev.Queue, ev.FuncPath).Inc()
+	case delayed.EventTaskDeferred:
+		e.deferred.WithLabelValues(ev.Queue, ev.FuncPath).Inc()
+	case delayed.EventTaskRequeued:
+		e.requeued.WithLabelValues(ev.Queue).Inc()
+	case delayed.EventDequeueFailed:
+		e.dequeueFails.WithLabelValues(ev.Queue).Inc()
+	}
+}
+
+// Registry returns the registry of the exporter.
+// Other collectors can be registered to it to be exported together.
+func (e *Exporter) Registry() *prometheus.Registry {
+	return e.registry
+}
+
+// ServeHTTP serves the metrics in the Prometheus exposition format.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.handler.ServeHTTP(w, r)
+}
+
+// Describe implements prometheus.Collector.
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	e.enqueued.Describe(ch)
+	e.processed.Describe(ch)
+	e.failed.Describe(ch)
+	e.canceled.Describe(ch)
+	e.deferred.Describe(ch)
+	e.duration.Describe(ch)
+	e.requeued.Describe(ch)
+	e.dequeueFails.Describe(ch)
+	ch <- e.queueLen
+	ch <- e.processingLen
+	ch <- e.paused
+}
+
+// Collect implements prometheus.Collector.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.enqueued.Collect(ch)
+	e.processed.Collect(ch)
+	e.failed.Collect(ch)
+	e.canceled.Collect(ch)
+	e.deferred.Collect(ch)
+	e.duration.Collect(ch)
+	e.requeued.Collect(ch)
+	e.dequeueFails.Collect(ch)
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, q := range e.queues {
+		count, err := q.Len()
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(e.queueLen, err)
+		} else {
+			ch <- prometheus.MustNewConstMetric(e.queueLen, prometheus.GaugeValue, float64(count), q.Name())
+		}
+
+		count, err = q.ProcessingLen()
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(e.processingLen, err)
+		} else {
+			ch <- prometheus.MustNewConstMetric(e.processingLen, prometheus.GaugeValue, float64(count), q.Name())
+		}
+
+		paused, err := q.IsPaused()
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(e.paused, err)
+		} else {
+			value := 0.0
+			if paused {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(e.paused, prometheus.GaugeValue, value, q.Name())
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yizhisec/go-delayed/delayed"
+)
+
+const redisAddr = ":6379"
+
+func scrape(t *testing.T, e *Exporter) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(w.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestExporter(t *testing.T) {
+	e := NewExporter()
+	q := delayed.NewQueue("test", delayed.NewRedisPool(redisAddr), delayed.OnEvent(e.HandleEvent))
+	defer q.Clear()
+	e.Watch(q)
+
+	err := q.Enqueue(delayed.NewGoTask("test.f", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.HandleEvent(&delayed.Event{Type: delayed.EventTaskSucceeded, Queue: "test", FuncPath: "test.f", Duration: time.Millisecond})
+	e.HandleEvent(&delayed.Event{Type: delayed.EventTaskFailed, Queue: "test", FuncPath: "test.f", Duration: time.Second})
+	e.HandleEvent(&delayed.Event{Type: delayed.EventTaskCanceled, Queue: "test", FuncPath: "test.f"})
+	e.HandleEvent(&delayed.Event{Type: delayed.EventTaskDeferred, Queue: "test", FuncPath: "test.f"})
+	e.HandleEvent(&delayed.Event{Type: delayed.EventTaskRequeued, Queue: "test"})
+	e.HandleEvent(&delayed.Event{Type: delayed.EventDequeueFailed, Queue: "test"})
+
+	body := scrape(t, e)
+	for _, line := range []string{
+		`delayed_tasks_enqueued_total{func_path="test.f",queue="test"} 1`,
+		`delayed_tasks_processed_total{func_path="test.f",queue="test"} 2`,
+		`delayed_tasks_failed_total{func_path="test.f",queue="test"} 1`,
+		`delayed_tasks_canceled_total{func_path="test.f",queue="test"} 1`,
+		`delayed_tasks_deferred_total{func_path="test.f",queue="test"} 1`,
+		`delayed_task_duration_seconds_count{func_path="test.f",queue="test"} 2`,
+		`delayed_lost_tasks_requeued_total{queue="test"} 1`,
+		`delayed_dequeue_errors_total{queue="test"} 1`,
+		`delayed_queue_length{queue="test"} 1`,
+		`delayed_processing_tasks{queue="test"} 0`,
+		`delayed_queue_paused{queue="test"} 0`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("%q not found in:\n%s", line, body)
+		}
+	}
+}
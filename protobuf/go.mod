@@ -0,0 +1,16 @@
+module github.com/yizhisec/go-delayed/protobuf
+
+go 1.19
+
+require (
+	github.com/yizhisec/go-delayed v0.0.0
+	google.golang.org/protobuf v1.30.0
+)
+
+require (
+	github.com/gomodule/redigo v1.8.9 // indirect
+	github.com/keakon/golog v0.0.0-20230323022214-04d52304f8cd // indirect
+	github.com/shamaton/msgpack/v2 v2.1.1 // indirect
+)
+
+replace github.com/yizhisec/go-delayed => ../
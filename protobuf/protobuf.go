@@ -0,0 +1,63 @@
+// Package protobuf provides a go-delayed codec serializing the args of tasks by Protocol Buffers.
+//
+// A task serialized by it should have only one arg, which is a proto.Message,
+// and its handler should accept a pointer to the same message type.
+package protobuf
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/yizhisec/go-delayed/delayed"
+	"google.golang.org/protobuf/proto"
+)
+
+var NotMessageError = errors.New("The arg is not a proto.Message")
+
+// Codec is the name of the codec recorded in the tasks.
+const Codec = "protobuf"
+
+type codec struct{}
+
+// New returns the Protocol Buffers codec, it's registered when this package is imported.
+func New() delayed.Codec {
+	return codec{}
+}
+
+func init() {
+	delayed.RegisterCodec(codec{})
+}
+
+func (codec) Name() string {
+	return Codec
+}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, NotMessageError
+	}
+	return proto.Marshal(m)
+}
+
+// Unmarshal decodes data into v, which should be a proto.Message or a pointer to one.
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+
+	// v is the pointer to the arg of a handler, eg: **pb.Message
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Ptr {
+		return NotMessageError
+	}
+	elem := rv.Elem()
+	if elem.IsNil() {
+		elem.Set(reflect.New(elem.Type().Elem()))
+	}
+	m, ok := elem.Interface().(proto.Message)
+	if !ok {
+		return NotMessageError
+	}
+	return proto.Unmarshal(data, m)
+}
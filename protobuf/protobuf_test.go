@@ -0,0 +1,48 @@
+package protobuf
+
+import (
+	"testing"
+
+	"github.com/yizhisec/go-delayed/delayed"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestCodec(t *testing.T) {
+	var got string
+	h := func(s *wrapperspb.StringValue) {
+		got = s.GetValue()
+	}
+	w := delayed.NewWorker(delayed.NewQueue("test", delayed.NewRedisPool(":6379")))
+	w.RegisterHandlerAs("test.protobuf", h)
+
+	task := delayed.NewGoTask("test.protobuf", wrapperspb.String("test"))
+	task.SetCodec(New())
+	data, err := task.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err = delayed.DeserializeGoTask(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Execute(task)
+	if got != "test" {
+		t.Errorf("got %q", got)
+	}
+
+	var s wrapperspb.StringValue
+	err = task.DecodeArg(&s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.GetValue() != "test" {
+		t.Errorf("decoded %q", s.GetValue())
+	}
+
+	task = delayed.NewGoTask("test.protobuf", "test")
+	task.SetCodec(New())
+	_, err = task.Serialize()
+	if err != NotMessageError {
+		t.Errorf("got error %v", err)
+	}
+}
@@ -0,0 +1,21 @@
+module github.com/yizhisec/go-delayed/tracing
+
+go 1.19
+
+require (
+	github.com/yizhisec/go-delayed v0.0.0
+	go.opentelemetry.io/otel v1.14.0
+	go.opentelemetry.io/otel/sdk v1.14.0
+	go.opentelemetry.io/otel/trace v1.14.0
+)
+
+require (
+	github.com/go-logr/logr v1.2.3 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/gomodule/redigo v1.8.9 // indirect
+	github.com/keakon/golog v0.0.0-20230323022214-04d52304f8cd // indirect
+	github.com/shamaton/msgpack/v2 v2.1.1 // indirect
+	golang.org/x/sys v0.7.0 // indirect
+)
+
+replace github.com/yizhisec/go-delayed => ../
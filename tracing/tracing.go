@@ -0,0 +1,177 @@
+// Package tracing propagates the OpenTelemetry trace context through go-delayed tasks.
+//
+// The trace context is injected into the headers of a GoTask when it's enqueued,
+// and extracted by the worker to start a consumer span as a child of the producer span.
+// The default propagator uses the W3C "traceparent" and "tracestate" headers,
+// which are also used by the OpenTelemetry SDKs of other languages.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/yizhisec/go-delayed/delayed"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName = "github.com/yizhisec/go-delayed/tracing"
+	systemName = "delayed"
+)
+
+var (
+	funcPathKey = attribute.Key("delayed.func_path")
+	outcomeKey  = attribute.Key("delayed.outcome")
+	attemptKey  = attribute.Key("delayed.attempt")
+)
+
+type config struct {
+	tracerProvider trace.TracerProvider
+	propagator     propagation.TextMapPropagator
+}
+
+// Option configures the interceptors.
+type Option func(*config)
+
+// WithTracerProvider sets the tracer provider, the global one is used by default.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *config) {
+		c.tracerProvider = tp
+	}
+}
+
+// WithPropagator sets the propagator, the global one is used by default.
+func WithPropagator(p propagation.TextMapPropagator) Option {
+	return func(c *config) {
+		c.propagator = p
+	}
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.tracerProvider == nil {
+		c.tracerProvider = otel.GetTracerProvider()
+	}
+	if c.propagator == nil {
+		c.propagator = otel.GetTextMapPropagator()
+	}
+	return c
+}
+
+// headerCarrier adapts the headers of a GoTask to propagation.TextMapCarrier.
+type headerCarrier struct {
+	task *delayed.GoTask
+}
+
+func (c headerCarrier) Get(key string) string {
+	return c.task.Headers()[key]
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c.task.SetHeader(key, value)
+}
+
+func (c headerCarrier) Keys() []string {
+	headers := c.task.Headers()
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// EnqueueInterceptor starts a producer span and injects its context into the headers of the task.
+// A PyTask has no headers, so only the span is recorded for it.
+func EnqueueInterceptor(opts ...Option) delayed.EnqueueInterceptor {
+	c := newConfig(opts)
+	tracer := c.tracerProvider.Tracer(tracerName)
+
+	return func(ctx context.Context, q *delayed.Queue, task delayed.Task, next func(context.Context) error) error {
+		attrs := []attribute.KeyValue{
+			semconv.MessagingSystem(systemName),
+			semconv.MessagingDestinationName(q.Name()),
+			funcPathKey.String(task.FuncPath()),
+		}
+		if id := task.ID(); id != "" {
+			attrs = append(attrs, semconv.MessagingMessageID(id))
+		}
+
+		ctx, span := tracer.Start(ctx, q.Name()+" publish",
+			trace.WithSpanKind(trace.SpanKindProducer),
+			trace.WithAttributes(attrs...),
+		)
+		defer span.End()
+
+		if t, ok := task.(*delayed.GoTask); ok {
+			c.propagator.Inject(ctx, headerCarrier{t})
+		}
+
+		err := next(ctx)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	}
+}
+
+// ExecuteInterceptor extracts the trace context from the headers of the task,
+// and starts a consumer span linked to the producer span.
+// The span has a "delayed.attempt" attribute if the attempt of the task is counted, see GoTask.Attempt().
+func ExecuteInterceptor(opts ...Option) delayed.ExecuteInterceptor {
+	c := newConfig(opts)
+	tracer := c.tracerProvider.Tracer(tracerName)
+
+	return func(ctx context.Context, w *delayed.Worker, task *delayed.GoTask, next func(context.Context) error) (err error) {
+		ctx = c.propagator.Extract(ctx, headerCarrier{task})
+
+		attrs := []attribute.KeyValue{
+			semconv.MessagingSystem(systemName),
+			semconv.MessagingDestinationName(w.Queue().Name()),
+			semconv.MessagingMessageID(task.ID()),
+			funcPathKey.String(task.FuncPath()),
+		}
+		if attempt := task.Attempt(); attempt > 0 {
+			attrs = append(attrs, attemptKey.Int(attempt))
+		}
+
+		startOpts := []trace.SpanStartOption{
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(attrs...),
+		}
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			startOpts = append(startOpts, trace.WithLinks(trace.Link{SpanContext: sc}))
+		}
+
+		ctx, span := tracer.Start(ctx, w.Queue().Name()+" process", startOpts...)
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("panic: %v", p)
+				end(span, err)
+				panic(p)
+			}
+			end(span, err)
+		}()
+
+		return next(ctx)
+	}
+}
+
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.SetAttributes(outcomeKey.String("failed"))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(outcomeKey.String("succeeded"))
+	}
+	span.End()
+}
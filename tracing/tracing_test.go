@@ -0,0 +1,91 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/yizhisec/go-delayed/delayed"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const redisAddr = ":6379"
+
+func Fail(s string) error {
+	return errors.New(s)
+}
+
+func TestInterceptors(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	opts := []Option{WithTracerProvider(tp), WithPropagator(propagation.TraceContext{})}
+
+	q := delayed.NewQueue("test", delayed.NewRedisPool(redisAddr), delayed.DequeueTimeout(time.Millisecond*2), delayed.InterceptEnqueue(EnqueueInterceptor(opts...)))
+	defer q.Clear()
+	w := delayed.NewWorker(q, delayed.InterceptExecute(ExecuteInterceptor(opts...)))
+	w.RegisterHandlers(Fail)
+
+	ctx, span := tp.Tracer("test").Start(context.Background(), "request")
+	err := q.EnqueueContext(ctx, delayed.NewGoTaskOfFunc(Fail, "error"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	span.End()
+
+	task, err := q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Headers()["traceparent"] == "" {
+		t.Fatalf("traceparent is not injected: %v", task.Headers())
+	}
+	w.Execute(task)
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans", len(spans))
+	}
+	producer, request, consumer := spans[0], spans[1], spans[2]
+	if producer.SpanKind() != trace.SpanKindProducer || producer.Parent().SpanID() != request.SpanContext().SpanID() {
+		t.Errorf("producer span is not a child of the request span")
+	}
+	if consumer.SpanKind() != trace.SpanKindConsumer || consumer.Parent().SpanID() != producer.SpanContext().SpanID() {
+		t.Errorf("consumer span is not a child of the producer span")
+	}
+	if len(consumer.Links()) != 1 || consumer.Links()[0].SpanContext.SpanID() != producer.SpanContext().SpanID() {
+		t.Errorf("consumer span is not linked to the producer span")
+	}
+	if consumer.Status().Code != codes.Error || consumer.Status().Description != "error" {
+		t.Errorf("got status %v", consumer.Status())
+	}
+	attempt := false
+	for _, attr := range consumer.Attributes() {
+		switch attr.Key {
+		case "messaging.destination.name":
+			if attr.Value.AsString() != "test" {
+				t.Errorf("got queue name %s", attr.Value.AsString())
+			}
+		case "messaging.message.id":
+			if attr.Value.AsString() != task.ID() {
+				t.Errorf("got task ID %s", attr.Value.AsString())
+			}
+		case "delayed.outcome":
+			if attr.Value.AsString() != "failed" {
+				t.Errorf("got outcome %s", attr.Value.AsString())
+			}
+		case "delayed.attempt":
+			attempt = true
+			if attr.Value.AsInt64() != 1 {
+				t.Errorf("got attempt %d", attr.Value.AsInt64())
+			}
+		}
+	}
+	if !attempt {
+		t.Error("the attempt is not recorded")
+	}
+}